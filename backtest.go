@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/backtest"
+	"github.com/jakekeeys/givforecast/internal/config"
+	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/store"
+)
+
+// runBacktest replays past days with the running config, optionally overridden by a JSON forecaster config file,
+// and prints the result as JSON. The store is locked while the server is running so stop it first.
+func runBacktest(cfg *config.Config, args []string) {
+	now := time.Now()
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	from := fs.String("from", time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -7).Format("2006-01-02"), "first day to replay")
+	days := fs.Int("days", 7, "number of days to replay")
+	configFile := fs.String("config", "", "JSON config applied over the environment's")
+	periods := fs.Bool("periods", false, "include each half hour period in the output")
+	_ = fs.Parse(args)
+
+	if cfg.CacheDir == "" {
+		panic("a cache dir must be set to backtest against the forecast history")
+	}
+
+	st, err := store.Open(path.Join(cfg.CacheDir, "givforecast.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer st.Close()
+
+	fd, err := time.ParseInLocation("2006-01-02", *from, time.Local)
+	if err != nil {
+		panic(fmt.Errorf("err parsing from: %w", err))
+	}
+
+	gec := newGivEnergyClient(cfg)
+	var fopts []forecaster.Option
+	if tc := newTariffClient(cfg, st); tc != nil {
+		fopts = append(fopts, forecaster.WithTariff(tc))
+	}
+
+	fc, err := cfg.ForecasterConfig(lastDiscovery(gec))
+	if err != nil {
+		panic(err)
+	}
+
+	// the runtime config changes apply over the config as when serving
+	fc = *forecaster.New(nil, gec, forecaster.WithConfig(&fc), forecaster.WithConfigDir(cfg.CacheDir)).GetConfig()
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			panic(err)
+		}
+
+		err = json.Unmarshal(data, &fc)
+		if err != nil {
+			panic(fmt.Errorf("err parsing %s: %w", *configFile, err))
+		}
+	}
+
+	err = fc.Validate()
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := backtest.NewBacktester(solcast.NewHistory(st), gec, fopts...).Run(fc, fd, *days)
+	if err != nil {
+		panic(err)
+	}
+
+	if !*periods {
+		for _, day := range result.Days {
+			day.Forecasts = nil
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(result)
+	if err != nil {
+		panic(err)
+	}
+}
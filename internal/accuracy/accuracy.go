@@ -0,0 +1,223 @@
+// Package accuracy compares the solar forecast and consumption assumptions a charge target was based on with what was measured
+package accuracy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/store"
+)
+
+const (
+	bucket    = "accuracy"
+	keyFormat = "2006-01-02"
+	// minMAPEW excludes periods with less actual power than this from MAPE, near zero actuals make it meaningless
+	minMAPEW = 100
+)
+
+type Tracker struct {
+	st  *store.Store
+	h   *solcast.History
+	gec *givenergy.Client
+	f   *forecaster.Forecaster
+}
+
+func NewTracker(st *store.Store, h *solcast.History, gec *givenergy.Client, f *forecaster.Forecaster) *Tracker {
+	return &Tracker{
+		st:  st,
+		h:   h,
+		gec: gec,
+		f:   f,
+	}
+}
+
+type Period struct {
+	PeriodEnd            time.Time `json:"period_end"`
+	ForecastSolarW       float64   `json:"forecast_solar_w"`
+	ActualSolarW         float64   `json:"actual_solar_w"`
+	ForecastConsumptionW float64   `json:"forecast_consumption_w"`
+	ActualConsumptionW   float64   `json:"actual_consumption_w"`
+}
+
+// Metrics summarise the error of forecast against actual power, positive bias is over forecasting
+type Metrics struct {
+	MAEW        float64 `json:"mae_w"`
+	BiasW       float64 `json:"bias_w"`
+	MAPE        float64 `json:"mape"` // percent, over periods with at least 100W actual
+	ForecastKwh float64 `json:"forecast_kwh"`
+	ActualKwh   float64 `json:"actual_kwh"`
+}
+
+type Day struct {
+	Date        time.Time `json:"date"`
+	Revision    time.Time `json:"revision"` // update time of the solar forecast revision compared against
+	Solar       Metrics   `json:"solar"`
+	Consumption Metrics   `json:"consumption"`
+	Periods     []Period  `json:"periods"`
+}
+
+type Rolling struct {
+	Days        int     `json:"days"`
+	Solar       Metrics `json:"solar"`
+	Consumption Metrics `json:"consumption"`
+}
+
+func metrics(periods []Period, forecast, actual func(p Period) float64) Metrics {
+	var m Metrics
+	if len(periods) == 0 {
+		return m
+	}
+
+	var apeTotal float64
+	var apeN int
+	for _, p := range periods {
+		f, a := forecast(p), actual(p)
+		m.MAEW = m.MAEW + math.Abs(f-a)
+		m.BiasW = m.BiasW + (f - a)
+		m.ForecastKwh = m.ForecastKwh + (f/1000)*0.5
+		m.ActualKwh = m.ActualKwh + (a/1000)*0.5
+		if a >= minMAPEW {
+			apeTotal = apeTotal + math.Abs(f-a)/a
+			apeN++
+		}
+	}
+
+	m.MAEW = m.MAEW / float64(len(periods))
+	m.BiasW = m.BiasW / float64(len(periods))
+	if apeN > 0 {
+		m.MAPE = (apeTotal / float64(apeN)) * 100
+	}
+
+	return m
+}
+
+func solarMetrics(periods []Period) Metrics {
+	return metrics(periods, func(p Period) float64 { return p.ForecastSolarW }, func(p Period) float64 { return p.ActualSolarW })
+}
+
+func consumptionMetrics(periods []Period) Metrics {
+	return metrics(periods, func(p Period) float64 { return p.ForecastConsumptionW }, func(p Period) float64 { return p.ActualConsumptionW })
+}
+
+// revision picks the forecast revision the day's last charge target was based on, falling back to the last revision
+// made before the day started. The consumption assumed for the target is returned with it, nil when there's none.
+func (t *Tracker) revision(d time.Time) (*solcast.ForecastData, map[time.Time]float64, error) {
+	dh, err := t.h.Day(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(dh.ChargeTargets) > 0 {
+		ct := dh.ChargeTargets[len(dh.ChargeTargets)-1]
+		fcd, err := t.h.Get(ct.Revision)
+		return fcd, ct.ConsumptionW, err
+	}
+
+	for i := len(dh.Revisions) - 1; i >= 0; i-- {
+		if dh.Revisions[i].UpdatedAt.Before(d) {
+			fcd, err := t.h.Get(dh.Revisions[i].UpdatedAt)
+			return fcd, nil, err
+		}
+	}
+
+	return nil, nil, fmt.Errorf("no forecast revision for %s", d.Format(keyFormat))
+}
+
+// Evaluate compares the day starting at d with the measured actuals and stores the result. Consumption is compared
+// with what the charge target assumed, the current consumption profile is only used for periods it didn't record.
+func (t *Tracker) Evaluate(d time.Time) (*Day, error) {
+	fcd, assumedConsumptionW, err := t.revision(d)
+	if err != nil {
+		return nil, err
+	}
+
+	actuals, err := t.gec.GetActuals(d, 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	day := &Day{Date: d, Revision: fcd.UpdatedAt, Periods: []Period{}}
+	for _, forecast := range fcd.Forecasts {
+		if !forecast.PeriodEnd.After(d) || forecast.PeriodEnd.After(d.AddDate(0, 0, 1)) {
+			continue
+		}
+
+		actual, ok := actuals[forecast.PeriodEnd.UTC()]
+		if !ok {
+			continue
+		}
+
+		consumptionW, ok := assumedConsumptionW[forecast.PeriodEnd.UTC()]
+		if !ok {
+			consumptionW, err = t.f.ConsumptionW(forecast.PeriodEnd)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		day.Periods = append(day.Periods, Period{
+			PeriodEnd:            forecast.PeriodEnd,
+			ForecastSolarW:       forecast.PvEstimate * 1000,
+			ActualSolarW:         actual.SolarW,
+			ForecastConsumptionW: consumptionW,
+			ActualConsumptionW:   actual.ConsumptionW,
+		})
+	}
+	if len(day.Periods) == 0 {
+		return nil, errors.New("no actuals overlap the forecast")
+	}
+
+	day.Solar = solarMetrics(day.Periods)
+	day.Consumption = consumptionMetrics(day.Periods)
+
+	err = t.st.Put(bucket, d.Format(keyFormat), day)
+	if err != nil {
+		return nil, err
+	}
+
+	return day, nil
+}
+
+// Days returns the stored results for the days days up to and including the day starting at to
+func (t *Tracker) Days(to time.Time, days int) ([]Day, error) {
+	var results []Day
+	from := to.AddDate(0, 0, -(days - 1))
+	err := t.st.Range(bucket, from.Format(keyFormat), to.AddDate(0, 0, 1).Format(keyFormat), func(_ string, decode func(v interface{}) error) error {
+		var day Day
+		err := decode(&day)
+		if err != nil {
+			return err
+		}
+		results = append(results, day)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Date.Before(results[j].Date)
+	})
+
+	return results, nil
+}
+
+// Roll combines the periods of days into overall metrics
+func Roll(days []Day) Rolling {
+	var periods []Period
+	for _, day := range days {
+		periods = append(periods, day.Periods...)
+	}
+
+	return Rolling{
+		Days:        len(days),
+		Solar:       solarMetrics(periods),
+		Consumption: consumptionMetrics(periods),
+	}
+}
@@ -0,0 +1,59 @@
+package accuracy
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/forecaster"
+)
+
+const (
+	// minBiasSamples is the fewest periods an hour of the month needs before a factor is learned for it
+	minBiasSamples = 4
+	// minBiasForecastW excludes periods forecast below this, dawn and dusk ratios are mostly noise
+	minBiasForecastW = 50
+	minBiasFactor    = 0.5
+	maxBiasFactor    = 2.0
+)
+
+// LearnBias learns solar bias factors from the stored accuracy of the days days up to and including the day starting at to.
+// Each factor is the ratio of actual to forecast production for its month and hour, hours without enough data are left unlearned.
+func (t *Tracker) LearnBias(to time.Time, days int) (forecaster.BiasFactors, error) {
+	var factors forecaster.BiasFactors
+
+	results, err := t.Days(to, days)
+	if err != nil {
+		return factors, err
+	}
+	if len(results) == 0 {
+		return factors, errors.New("no accuracy history to learn from")
+	}
+
+	var forecastW, actualW [12][24]float64
+	var samples [12][24]int
+	for _, day := range results {
+		for _, p := range day.Periods {
+			if p.ForecastSolarW < minBiasForecastW {
+				continue
+			}
+
+			mid := p.PeriodEnd.Add(-15 * time.Minute).Local()
+			m, h := mid.Month()-1, mid.Hour()
+			forecastW[m][h] = forecastW[m][h] + p.ForecastSolarW
+			actualW[m][h] = actualW[m][h] + p.ActualSolarW
+			samples[m][h]++
+		}
+	}
+
+	for m := range factors {
+		for h := range factors[m] {
+			if samples[m][h] < minBiasSamples {
+				continue
+			}
+			factors[m][h] = math.Max(minBiasFactor, math.Min(maxBiasFactor, actualW[m][h]/forecastW[m][h]))
+		}
+	}
+
+	return factors, nil
+}
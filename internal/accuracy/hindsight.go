@@ -0,0 +1,147 @@
+package accuracy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+)
+
+const (
+	hindsightBucket = "hindsight"
+	// hindsightToleranceSOC is how far a target can be from ideal before it counts as over or under charged
+	hindsightToleranceSOC = 1
+)
+
+// Hindsight compares the charge target set for a day with the ideal target given the measured solar and consumption
+type Hindsight struct {
+	Date              time.Time `json:"date"`
+	SetAt             time.Time `json:"set_at"`
+	RecommendedTarget float64   `json:"recommended_target"`
+	IdealTarget       float64   `json:"ideal_target"`
+	ErrorSOC          float64   `json:"error_soc"`      // recommended less ideal, positive when over charged
+	ProductionKwh     float64   `json:"production_kwh"` // measured over the simulated day
+	ConsumptionKwh    float64   `json:"consumption_kwh"`
+}
+
+type HindsightSummary struct {
+	Days            int     `json:"days"`
+	MeanErrorSOC    float64 `json:"mean_error_soc"`
+	MeanAbsErrorSOC float64 `json:"mean_abs_error_soc"`
+	OverCharged     int     `json:"over_charged"`
+	UnderCharged    int     `json:"under_charged"`
+}
+
+// Hindsight works out the ideal charge target for the day starting at d from the measured actuals, compares it with
+// the last target set for the day and stores the result
+func (t *Tracker) Hindsight(d time.Time) (*Hindsight, error) {
+	dh, err := t.h.Day(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(dh.ChargeTargets) == 0 {
+		return nil, fmt.Errorf("no charge target was set for %s", d.Format(keyFormat))
+	}
+	ct := dh.ChargeTargets[len(dh.ChargeTargets)-1]
+
+	// the simulated day runs into the early hours of the next
+	actuals := make(map[time.Time]givenergy.Actual)
+	for _, day := range []time.Time{d, d.AddDate(0, 0, 1)} {
+		if day.After(time.Now()) {
+			continue
+		}
+
+		dayActuals, err := t.gec.GetActuals(day, 30*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		for pe, actual := range dayActuals {
+			actuals[pe] = actual
+		}
+	}
+
+	ideal, err := t.f.Hindsight(d, actuals)
+	if err != nil {
+		return nil, err
+	}
+
+	hs := &Hindsight{
+		Date:              d,
+		SetAt:             ct.SetAt,
+		RecommendedTarget: ct.TargetSOC,
+		IdealTarget:       ideal.RecommendedChargeTarget,
+		ErrorSOC:          ct.TargetSOC - ideal.RecommendedChargeTarget,
+		ProductionKwh:     ideal.ProductionKwh,
+		ConsumptionKwh:    ideal.ConsumptionKwh,
+	}
+
+	err = t.st.Put(hindsightBucket, d.Format(keyFormat), hs)
+	if err != nil {
+		return nil, err
+	}
+
+	return hs, nil
+}
+
+// HindsightDay returns the stored hindsight for the day starting at d, nil when it hasn't been evaluated
+func (t *Tracker) HindsightDay(d time.Time) (*Hindsight, error) {
+	hs := &Hindsight{}
+	ok, err := t.st.Get(hindsightBucket, d.Format(keyFormat), hs)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	return hs, nil
+}
+
+// HindsightDays returns the stored hindsight for the days days up to and including the day starting at to
+func (t *Tracker) HindsightDays(to time.Time, days int) ([]Hindsight, error) {
+	results := []Hindsight{}
+	from := to.AddDate(0, 0, -(days - 1))
+	err := t.st.Range(hindsightBucket, from.Format(keyFormat), to.AddDate(0, 0, 1).Format(keyFormat), func(_ string, decode func(v interface{}) error) error {
+		var hs Hindsight
+		err := decode(&hs)
+		if err != nil {
+			return err
+		}
+		results = append(results, hs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Date.Before(results[j].Date)
+	})
+
+	return results, nil
+}
+
+// SummariseHindsight combines days into how far off the targets set were on average
+func SummariseHindsight(days []Hindsight) HindsightSummary {
+	summary := HindsightSummary{Days: len(days)}
+	if len(days) == 0 {
+		return summary
+	}
+
+	for _, hs := range days {
+		summary.MeanErrorSOC = summary.MeanErrorSOC + hs.ErrorSOC
+		summary.MeanAbsErrorSOC = summary.MeanAbsErrorSOC + math.Abs(hs.ErrorSOC)
+		if hs.ErrorSOC > hindsightToleranceSOC {
+			summary.OverCharged++
+		}
+		if hs.ErrorSOC < -hindsightToleranceSOC {
+			summary.UnderCharged++
+		}
+	}
+	summary.MeanErrorSOC = summary.MeanErrorSOC / float64(len(days))
+	summary.MeanAbsErrorSOC = summary.MeanAbsErrorSOC / float64(len(days))
+
+	return summary
+}
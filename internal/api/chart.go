@@ -6,11 +6,15 @@ import (
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/components"
 	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/jakekeeys/givforecast/internal/accuracy"
 	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"sort"
+	"strings"
 	"time"
 )
 
-func ForcastToCharts(f *forecaster.ForecastDay) ([]byte, error) {
+// ForcastToCharts renders the forecast, overlaying the replanned state of charge when replan is not nil
+func ForcastToCharts(f *forecaster.ForecastDay, replan *forecaster.ForecastDay) ([]byte, error) {
 	productionChart := charts.NewLine()
 	productionChart.SetGlobalOptions(
 		charts.WithTitleOpts(opts.Title{
@@ -28,10 +32,50 @@ func ForcastToCharts(f *forecaster.ForecastDay) ([]byte, error) {
 	productionChart.SetXAxis(xAxis).
 		AddSeries("W", yAxis)
 
+	var sites []string
+	if len(f.Forecasts) > 0 {
+		for site := range f.Forecasts[0].SiteProductionW {
+			sites = append(sites, site)
+		}
+		sort.Strings(sites)
+	}
+	for _, site := range sites {
+		var siteAxis []opts.LineData
+		for _, proj := range f.Forecasts {
+			siteAxis = append(siteAxis, opts.LineData{
+				Value:  proj.SiteProductionW[site] / 1000,
+				Symbol: "Kw",
+			})
+		}
+		productionChart.AddSeries(site, siteAxis)
+	}
+
+	for _, scenario := range f.Scenarios {
+		if scenario.Scenario == f.Scenario {
+			continue
+		}
+
+		var scenarioAxis []opts.LineData
+		for _, proj := range scenario.Forecasts {
+			scenarioAxis = append(scenarioAxis, opts.LineData{
+				Value:  proj.ProductionW / 1000,
+				Symbol: "Kw",
+			})
+		}
+		productionChart.AddSeries(strings.ToUpper(scenario.Scenario), scenarioAxis)
+	}
+
+	socTitle := fmt.Sprintf("SOC (Overnight Target %.0f%% @ %s)", f.RecommendedChargeTarget, strings.ToUpper(f.Scenario))
+	if len(f.ChargeSlots) > 0 {
+		socTitle = fmt.Sprintf("SOC (Target %.0f%% @ %s, %d Charge Slots, Import %.0fp)", f.RecommendedChargeTarget, strings.ToUpper(f.Scenario), len(f.ChargeSlots), f.ImportCostPence)
+	}
+	if len(f.ExportSlots) > 0 {
+		socTitle = fmt.Sprintf("%s, %d Export Slots, Export %.0fp)", strings.TrimSuffix(socTitle, ")"), len(f.ExportSlots), f.ExportRevenuePence)
+	}
 	socChart := charts.NewLine()
 	socChart.SetGlobalOptions(
 		charts.WithTitleOpts(opts.Title{
-			Title: fmt.Sprintf("SOC (Overnight Target %.0f%%)", f.RecommendedChargeTarget),
+			Title: socTitle,
 		}))
 	xAxis = []string{}
 	yAxis = []opts.LineData{}
@@ -43,6 +87,36 @@ func ForcastToCharts(f *forecaster.ForecastDay) ([]byte, error) {
 	}
 	socChart.SetXAxis(xAxis).
 		AddSeries("%", yAxis)
+	for _, scenario := range f.Scenarios {
+		if scenario.Scenario == f.Scenario {
+			continue
+		}
+
+		var scenarioAxis []opts.LineData
+		for _, proj := range scenario.Forecasts {
+			scenarioAxis = append(scenarioAxis, opts.LineData{
+				Value: proj.SOC,
+			})
+		}
+		socChart.AddSeries(fmt.Sprintf("%s (%.0f%%)", strings.ToUpper(scenario.Scenario), scenario.RecommendedChargeTarget), scenarioAxis)
+	}
+	if replan != nil {
+		replanSOC := make(map[time.Time]float64)
+		for _, proj := range replan.Forecasts {
+			replanSOC[proj.PeriodEnd.UTC()] = proj.SOC
+		}
+
+		var replanAxis []opts.LineData
+		for _, proj := range f.Forecasts {
+			soc, ok := replanSOC[proj.PeriodEnd.UTC()]
+			if !ok {
+				replanAxis = append(replanAxis, opts.LineData{Value: "-"})
+				continue
+			}
+			replanAxis = append(replanAxis, opts.LineData{Value: soc})
+		}
+		socChart.AddSeries(fmt.Sprintf("Replanned %s", replan.ReplannedAt.Format(time.Kitchen)), replanAxis)
+	}
 
 	chargeDischargeChart := charts.NewLine()
 	chargeDischargeChart.SetGlobalOptions(
@@ -69,6 +143,31 @@ func ForcastToCharts(f *forecaster.ForecastDay) ([]byte, error) {
 		AddSeries("Discharge", yAxis).
 		AddSeries("Charge", yAxis2)
 
+	gridChart := charts.NewLine()
+	gridChart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("Grid (Import %.1fKwh, Export %.1fKwh)", f.GridImportKwh, f.GridExportKwh),
+		}))
+	xAxis = []string{}
+	yAxis = []opts.LineData{}
+	yAxis2 = []opts.LineData{}
+	for _, proj := range f.Forecasts {
+		xAxis = append(xAxis, proj.PeriodEnd.Format(time.Kitchen))
+		yAxis = append(yAxis, opts.LineData{
+			Name:   "Import",
+			Symbol: "Kw",
+			Value:  proj.GridImportW / 1000,
+		})
+		yAxis2 = append(yAxis2, opts.LineData{
+			Name:   "Export",
+			Symbol: "Kw",
+			Value:  proj.GridExportW / 1000,
+		})
+	}
+	gridChart.SetXAxis(xAxis).
+		AddSeries("Import", yAxis).
+		AddSeries("Export", yAxis2)
+
 	consumptionChart := charts.NewLine()
 	consumptionChart.SetGlobalOptions(
 		charts.WithTitleOpts(opts.Title{
@@ -92,6 +191,141 @@ func ForcastToCharts(f *forecaster.ForecastDay) ([]byte, error) {
 	page.AddCharts(chargeDischargeChart)
 	page.AddCharts(productionChart)
 	page.AddCharts(consumptionChart)
+	page.AddCharts(gridChart)
+
+	bodyBuf := bytes.NewBuffer([]byte{})
+
+	err := page.Render(bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	return bodyBuf.Bytes(), nil
+}
+
+func RangeToCharts(fr *forecaster.ForecastRange) ([]byte, error) {
+	socChart := charts.NewLine()
+	socChart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("SOC (%d Days)", len(fr.Days)),
+		}))
+	var xAxis []string
+	var yAxis []opts.LineData
+	for _, fd := range fr.Days {
+		for _, proj := range fd.Forecasts {
+			xAxis = append(xAxis, proj.PeriodEnd.Format("Mon 3:04PM"))
+			yAxis = append(yAxis, opts.LineData{
+				Value: proj.SOC,
+			})
+		}
+	}
+	socChart.SetXAxis(xAxis).
+		AddSeries("%", yAxis)
+
+	dayChart := charts.NewBar()
+	dayChart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Daily Totals",
+		}))
+	xAxis = []string{}
+	var productionAxis, consumptionAxis, importAxis, exportAxis, targetAxis []opts.BarData
+	for _, fd := range fr.Days {
+		xAxis = append(xAxis, fd.Date.Format("Mon 02"))
+		productionAxis = append(productionAxis, opts.BarData{
+			Value: fd.ProductionKwh,
+		})
+		consumptionAxis = append(consumptionAxis, opts.BarData{
+			Value: fd.ConsumptionKwh,
+		})
+		importAxis = append(importAxis, opts.BarData{
+			Value: fd.GridImportKwh,
+		})
+		exportAxis = append(exportAxis, opts.BarData{
+			Value: fd.GridExportKwh,
+		})
+		targetAxis = append(targetAxis, opts.BarData{
+			Value: fd.RecommendedChargeTarget,
+		})
+	}
+	dayChart.SetXAxis(xAxis).
+		AddSeries("Production Kwh", productionAxis).
+		AddSeries("Consumption Kwh", consumptionAxis).
+		AddSeries("Import Kwh", importAxis).
+		AddSeries("Export Kwh", exportAxis).
+		AddSeries("Charge Target %", targetAxis)
+
+	page := components.NewPage()
+	page.SetLayout(components.PageFlexLayout)
+
+	page.AddCharts(socChart)
+	page.AddCharts(dayChart)
+
+	bodyBuf := bytes.NewBuffer([]byte{})
+
+	err := page.Render(bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	return bodyBuf.Bytes(), nil
+}
+
+// AccuracyToCharts renders the daily forecast error with the rolling accuracy in the titles
+func AccuracyToCharts(days []accuracy.Day, rolling accuracy.Rolling) ([]byte, error) {
+	errorChart := charts.NewLine()
+	errorChart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("MAPE %% (%d Days, Solar %.0f%%, Consumption %.0f%%)", rolling.Days, rolling.Solar.MAPE, rolling.Consumption.MAPE),
+		}))
+	var xAxis []string
+	var solarAxis, consumptionAxis []opts.LineData
+	for _, day := range days {
+		xAxis = append(xAxis, day.Date.Format("Mon 02"))
+		solarAxis = append(solarAxis, opts.LineData{Value: day.Solar.MAPE})
+		consumptionAxis = append(consumptionAxis, opts.LineData{Value: day.Consumption.MAPE})
+	}
+	errorChart.SetXAxis(xAxis).
+		AddSeries("Solar", solarAxis).
+		AddSeries("Consumption", consumptionAxis)
+
+	biasChart := charts.NewLine()
+	biasChart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("Bias W (Solar %.0fW, Consumption %.0fW)", rolling.Solar.BiasW, rolling.Consumption.BiasW),
+		}))
+	solarAxis, consumptionAxis = []opts.LineData{}, []opts.LineData{}
+	for _, day := range days {
+		solarAxis = append(solarAxis, opts.LineData{Value: day.Solar.BiasW})
+		consumptionAxis = append(consumptionAxis, opts.LineData{Value: day.Consumption.BiasW})
+	}
+	biasChart.SetXAxis(xAxis).
+		AddSeries("Solar", solarAxis).
+		AddSeries("Consumption", consumptionAxis)
+
+	dayChart := charts.NewBar()
+	dayChart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Daily Totals",
+		}))
+	var forecastSolarAxis, actualSolarAxis, forecastConsumptionAxis, actualConsumptionAxis []opts.BarData
+	for _, day := range days {
+		forecastSolarAxis = append(forecastSolarAxis, opts.BarData{Value: day.Solar.ForecastKwh})
+		actualSolarAxis = append(actualSolarAxis, opts.BarData{Value: day.Solar.ActualKwh})
+		forecastConsumptionAxis = append(forecastConsumptionAxis, opts.BarData{Value: day.Consumption.ForecastKwh})
+		actualConsumptionAxis = append(actualConsumptionAxis, opts.BarData{Value: day.Consumption.ActualKwh})
+	}
+	dayChart.SetXAxis(xAxis).
+		AddSeries("Forecast Solar Kwh", forecastSolarAxis).
+		AddSeries("Actual Solar Kwh", actualSolarAxis).
+		AddSeries("Forecast Consumption Kwh", forecastConsumptionAxis).
+		AddSeries("Actual Consumption Kwh", actualConsumptionAxis)
+
+	page := components.NewPage()
+	page.SetLayout(components.PageFlexLayout)
+
+	page.AddCharts(errorChart)
+	page.AddCharts(biasChart)
+	page.AddCharts(dayChart)
 
 	bodyBuf := bytes.NewBuffer([]byte{})
 
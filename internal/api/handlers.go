@@ -1,32 +1,28 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/jakekeeys/givforecast/internal/accuracy"
+	"github.com/jakekeeys/givforecast/internal/backtest"
 	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/givenergy"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/tariff"
 )
 
 func (s *Server) RootHandler(c *gin.Context) {
-	d := time.Now()
-
-	ds := c.Query("date")
-	if ds != "" {
-		switch ds {
-		case "tomorrow":
-			d = d.Add(24 * time.Hour)
-		default:
-			tp, err := time.Parse(dateFormat, ds)
-			if err != nil {
-				c.String(http.StatusBadRequest, err.Error())
-				return
-			}
-			d = tp
-		}
+	d, err := parseDate(c.Query("date"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
 	}
 
 	forecast, err := s.f.Forecast(d)
@@ -35,7 +31,7 @@ func (s *Server) RootHandler(c *gin.Context) {
 		return
 	}
 
-	charts, err := ForcastToCharts(forecast)
+	charts, err := ForcastToCharts(forecast, s.f.LastReplan(forecast.Date))
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -48,6 +44,86 @@ func (s *Server) RootHandler(c *gin.Context) {
 	}
 }
 
+func (s *Server) RangeChartHandler(c *gin.Context) {
+	fr, ok := s.forecastRange(c)
+	if !ok {
+		return
+	}
+
+	charts, err := RangeToCharts(fr)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = c.Writer.Write(charts)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (s *Server) ForecastRangeHandler(c *gin.Context) {
+	fr, ok := s.forecastRange(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, fr)
+}
+
+func (s *Server) forecastRange(c *gin.Context) (*forecaster.ForecastRange, bool) {
+	d, err := parseDate(c.Query("start"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	days := 7
+	dys := c.Query("days")
+	if dys != "" {
+		days, err = strconv.Atoi(dys)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Local().Year(), now.Local().Month(), now.Local().Day(), 0, 0, 0, 0, time.Local)
+	if d.Before(today) || days < 1 || d.AddDate(0, 0, days-1).After(today.AddDate(0, 0, 6)) {
+		c.String(http.StatusBadRequest, "range must start today or later and end < 7 days in the future")
+		return nil, false
+	}
+
+	fr, err := s.f.ForecastRange(d, days)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	return fr, true
+}
+
+func parseDate(ds string) (time.Time, error) {
+	d := time.Now()
+
+	if ds != "" {
+		switch ds {
+		case "tomorrow":
+			d = d.Add(24 * time.Hour)
+		default:
+			tp, err := time.Parse(dateFormat, ds)
+			if err != nil {
+				return time.Time{}, err
+			}
+			d = tp
+		}
+	}
+
+	return d, nil
+}
+
 func (s *Server) UpdateChargeTargetHandler(c *gin.Context) {
 	err := s.UpdateChargeTarget()
 	if err != nil {
@@ -68,20 +144,12 @@ func (s *Server) SetChargeTargetHandler(c *gin.Context) {
 		return
 	}
 
-	maxRetries := 10
-	for i := 1; i < maxRetries+1; i++ {
-		err := s.gec.SetChargeUpperLimit(ctr.ChargeToPercent)
-		if err != nil {
-			println(fmt.Errorf("setting charge target failed, attempt %d/%d waiting and retrying, err: %w", i, maxRetries, err).Error())
-			time.Sleep(time.Second * time.Duration(i*3))
-		} else {
-			break
-		}
-
-		if i == maxRetries {
-			c.String(http.StatusInternalServerError, err.Error())
-			return
-		}
+	err = withRetries("setting charge target", func() error {
+		return s.ic.SetChargeTarget(ctr.ChargeToPercent)
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
 }
 
@@ -98,6 +166,14 @@ func (s *Server) ForecastNowHandler(c *gin.Context) {
 		t = pt
 	}
 
+	if c.Query("replan") == "true" {
+		_, err := s.Replan()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	fn, err := s.f.ForecastNow(t)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
@@ -107,22 +183,21 @@ func (s *Server) ForecastNowHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, fn)
 }
 
-func (s *Server) ForecastHandler(c *gin.Context) {
-	d := time.Now()
+func (s *Server) ReplanHandler(c *gin.Context) {
+	fd, err := s.Replan()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	ds := c.Query("date")
-	if ds != "" {
-		switch ds {
-		case "tomorrow":
-			d = d.Add(24 * time.Hour)
-		default:
-			tp, err := time.Parse(dateFormat, ds)
-			if err != nil {
-				c.String(http.StatusBadRequest, err.Error())
-				return
-			}
-			d = tp
-		}
+	c.JSON(http.StatusOK, fd)
+}
+
+func (s *Server) ForecastHandler(c *gin.Context) {
+	d, err := parseDate(c.Query("date"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
 	}
 
 	now := time.Now().UTC()
@@ -155,13 +230,26 @@ func (s *Server) ConfigHandler(c *gin.Context) {
 func (s *Server) SetConfigHandler(c *gin.Context) {
 	var config forecaster.Config
 	err := c.ShouldBindJSON(&config)
+	if err != nil {
+		badRequest(c, "", err)
+		return
+	}
+
+	s.updateConfig(c, func(current *forecaster.Config) {
+		*current = config
+	})
+}
+
+// ResetConfigHandler drops the config changes made at runtime, returning to the configured values. Learned bias
+// factors are kept, relearn them with POST /forecast/config/biasfactors.
+func (s *Server) ResetConfigHandler(c *gin.Context) {
+	err := s.f.ResetConfig()
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	s.f.SetConfig(config)
-	return
+	c.JSON(http.StatusOK, s.f.GetConfig())
 }
 
 func (s *Server) SetConsumptionAverage(c *gin.Context) {
@@ -171,15 +259,13 @@ func (s *Server) SetConsumptionAverage(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&value)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		badRequest(c, "value", err)
 		return
 	}
 
-	config := s.f.GetConfig()
-	config.AvgConsumptionKw = value.Value
-
-	s.f.SetConfig(*config)
-	return
+	s.updateConfig(c, func(config *forecaster.Config) {
+		config.AvgConsumptionKw = value.Value
+	})
 }
 
 func (s *Server) SetBatteryUpper(c *gin.Context) {
@@ -189,15 +275,13 @@ func (s *Server) SetBatteryUpper(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&value)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		badRequest(c, "value", err)
 		return
 	}
 
-	config := s.f.GetConfig()
-	config.BatteryUpperReserve = value.Value
-
-	s.f.SetConfig(*config)
-	return
+	s.updateConfig(c, func(config *forecaster.Config) {
+		config.BatteryUpperReserve = value.Value
+	})
 }
 
 func (s *Server) SetBatteryLower(c *gin.Context) {
@@ -207,15 +291,13 @@ func (s *Server) SetBatteryLower(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&value)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		badRequest(c, "value", err)
 		return
 	}
 
-	config := s.f.GetConfig()
-	config.BatteryLowerReserve = value.Value
-
-	s.f.SetConfig(*config)
-	return
+	s.updateConfig(c, func(config *forecaster.Config) {
+		config.BatteryLowerReserve = value.Value
+	})
 }
 
 func (s *Server) SetAutomaticTargets(c *gin.Context) {
@@ -224,29 +306,101 @@ func (s *Server) SetAutomaticTargets(c *gin.Context) {
 	}
 
 	err := c.ShouldBindJSON(&value)
+	if err != nil {
+		badRequest(c, "value", err)
+		return
+	}
+
+	s.updateConfig(c, func(config *forecaster.Config) {
+		config.AutomaticTargetsEnabled = value.Value
+	})
+}
+
+func (s *Server) SetBiasCorrection(c *gin.Context) {
+	var value struct {
+		Value bool `json:"value"`
+	}
+
+	err := c.ShouldBindJSON(&value)
+	if err != nil {
+		badRequest(c, "value", err)
+		return
+	}
+
+	s.updateConfig(c, func(config *forecaster.Config) {
+		config.BiasCorrectionEnabled = value.Value
+	})
+}
+
+// updateConfig applies update to a copy of the config and sets it, responding 400 with the field errors when the
+// result is invalid
+func (s *Server) updateConfig(c *gin.Context, update func(config *forecaster.Config)) {
+	config := s.f.GetConfig()
+	update(config)
+
+	err := s.f.SetConfig(*config)
+	var ve forecaster.ValidationError
+	if errors.As(err, &ve) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": ve})
+		return
+	}
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	c.JSON(http.StatusOK, s.f.GetConfig())
+}
+
+// badRequest responds 400 with err as the error for field in the same shape as a config validation failure
+func badRequest(c *gin.Context, field string, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{"errors": forecaster.ValidationError{{Field: field, Message: err.Error()}}})
+}
+
+func (s *Server) GetBiasFactorsHandler(c *gin.Context) {
 	config := s.f.GetConfig()
-	config.AutomaticTargetsEnabled = value.Value
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": config.BiasCorrectionEnabled,
+		"factors": config.BiasFactors,
+	})
+}
 
-	s.f.SetConfig(*config)
-	return
+func (s *Server) LearnBiasFactorsHandler(c *gin.Context) {
+	days := 90
+	if ds := c.Query("days"); ds != "" {
+		v, err := strconv.Atoi(ds)
+		if err != nil || v < 1 {
+			c.String(http.StatusBadRequest, "days must be a positive integer")
+			return
+		}
+		days = v
+	}
+
+	factors, err := s.LearnBias(days)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, factors)
 }
 
-//func (s *Server) SetConsumptionAveragesHandler(c *gin.Context) {
-//	var data map[time.Time]float64
-//	err := c.ShouldBindJSON(&data)
-//	if err != nil {
-//		c.String(http.StatusInternalServerError, err.Error())
-//		return
-//	}
-//
-//	s.gec.SetConsumptionAverages(data)
-//	return
-//}
+func (s *Server) SetConsumptionAveragesHandler(c *gin.Context) {
+	var data givenergy.ConsumptionAverages
+	err := c.ShouldBindJSON(&data)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = s.gec.SetConsumptionAverages(data)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	return
+}
 
 //func (s *Server) GetBatteryDataHandler(c *gin.Context) {
 //	data, err := s.gec.GetBatteryData()
@@ -258,23 +412,37 @@ func (s *Server) SetAutomaticTargets(c *gin.Context) {
 //	c.JSON(http.StatusOK, data)
 //}
 
-//func (s *Server) GetConsumptionAveragesHandler(c *gin.Context) {
-//	averages, err := s.gec.GetConsumptionAverages()
-//	if err != nil {
-//		c.String(http.StatusInternalServerError, err.Error())
-//		return
-//	}
-//
-//	c.JSON(http.StatusOK, averages)
-//}
+func (s *Server) GetConsumptionAveragesHandler(c *gin.Context) {
+	averages, err := s.gec.GetConsumptionAverages()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 
-//func (s *Server) UpdateConsumptionAveragesHandler(c *gin.Context) {
-//	err := s.gec.UpdateConsumptionAverages()
-//	if err != nil {
-//		c.String(http.StatusInternalServerError, err.Error())
-//		return
-//	}
-//}
+	c.JSON(http.StatusOK, averages)
+}
+
+func (s *Server) GetVerificationsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.gec.GetVerifications())
+}
+
+func (s *Server) GetDiscoveryHandler(c *gin.Context) {
+	discovery, err := s.gec.GetDiscovery()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, discovery)
+}
+
+func (s *Server) UpdateConsumptionAveragesHandler(c *gin.Context) {
+	err := s.gec.UpdateConsumptionAverages()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+}
 
 func (s *Server) SetForecastDataHandler(c *gin.Context) {
 	var data solcast.ForecastData
@@ -314,6 +482,305 @@ func (s *Server) GetForecastDataHandler(c *gin.Context) {
 	return
 }
 
+func (s *Server) GetForecastHistoryHandler(c *gin.Context) {
+	if s.h == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return
+	}
+
+	d, err := parseDate(c.Query("date"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dh, err := s.h.Day(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, dh)
+}
+
+// accuracyDays returns the stored accuracy for the days query param days up to yesterday, 14 by default
+func (s *Server) accuracyDays(c *gin.Context) ([]accuracy.Day, bool) {
+	if s.at == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return nil, false
+	}
+
+	days := 14
+	if ds := c.Query("days"); ds != "" {
+		v, err := strconv.Atoi(ds)
+		if err != nil || v < 1 {
+			c.String(http.StatusBadRequest, "days must be a positive integer")
+			return nil, false
+		}
+		days = v
+	}
+
+	now := time.Now()
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+	results, err := s.at.Days(yesterday, days)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	return results, true
+}
+
+func (s *Server) GetAccuracyHandler(c *gin.Context) {
+	days, ok := s.accuracyDays(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rolling": accuracy.Roll(days),
+		"days":    days,
+	})
+}
+
+func (s *Server) AccuracyChartHandler(c *gin.Context) {
+	days, ok := s.accuracyDays(c)
+	if !ok {
+		return
+	}
+
+	charts, err := AccuracyToCharts(days, accuracy.Roll(days))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = c.Writer.Write(charts)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (s *Server) EvaluateAccuracyHandler(c *gin.Context) {
+	if s.at == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return
+	}
+
+	if c.Query("date") == "" {
+		day, err := s.EvaluateAccuracy()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, day)
+		return
+	}
+
+	d, err := parseDate(c.Query("date"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	day, err := s.at.Evaluate(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, day)
+}
+
+// GetHindsightHandler returns the stored comparison of the charge target with the ideal target for the date query
+// param, without a date it returns the comparisons for the days query param days up to yesterday, 14 by default
+func (s *Server) GetHindsightHandler(c *gin.Context) {
+	if s.at == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return
+	}
+
+	if c.Query("date") != "" {
+		d, err := parseDate(c.Query("date"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		hs, err := s.at.HindsightDay(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local))
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if hs == nil {
+			c.String(http.StatusNotFound, fmt.Sprintf("no hindsight evaluated for %s", d.Format(dateFormat)))
+			return
+		}
+
+		c.JSON(http.StatusOK, hs)
+		return
+	}
+
+	days := 14
+	if ds := c.Query("days"); ds != "" {
+		v, err := strconv.Atoi(ds)
+		if err != nil || v < 1 {
+			c.String(http.StatusBadRequest, "days must be a positive integer")
+			return
+		}
+		days = v
+	}
+
+	now := time.Now()
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+	results, err := s.at.HindsightDays(yesterday, days)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"summary": accuracy.SummariseHindsight(results),
+		"days":    results,
+	})
+}
+
+// EvaluateHindsightHandler compares the charge target set for the date query param, yesterday by default, with the
+// ideal target given what was measured and stores the result
+func (s *Server) EvaluateHindsightHandler(c *gin.Context) {
+	if s.at == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return
+	}
+
+	if c.Query("date") == "" {
+		hs, err := s.EvaluateHindsight()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, hs)
+		return
+	}
+
+	d, err := parseDate(c.Query("date"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hs, err := s.at.Hindsight(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, hs)
+}
+
+func (s *Server) SubmitSolarActualsHandler(c *gin.Context) {
+	period := c.Query("period")
+	if period == "" {
+		period = "PT10M"
+	}
+
+	submission, err := s.SubmitSolarActuals(period)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, submission)
+}
+
+func (s *Server) GetSolarActualsSubmissionsHandler(c *gin.Context) {
+	if s.h == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return
+	}
+
+	subs, err := s.h.Submissions(time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, subs)
+}
+
+func (s *Server) GetSolcastBudgetHandler(c *gin.Context) {
+	if s.scr != nil {
+		c.JSON(http.StatusOK, s.scr.Status())
+		return
+	}
+
+	sc, ok := s.sc.(*solcast.Client)
+	if !ok {
+		c.String(http.StatusNotFound, "solcast is not the solar forecast provider")
+		return
+	}
+
+	budget := sc.GetBudget()
+	c.JSON(http.StatusOK, solcast.RefresherStatus{
+		Budget:    budget,
+		Remaining: budget.Remaining(),
+	})
+}
+
+func (s *Server) SetTariffRatesHandler(c *gin.Context) {
+	if s.tc == nil {
+		c.String(http.StatusNotFound, "no tariff configured")
+		return
+	}
+
+	var data tariff.RateData
+	err := c.ShouldBindJSON(&data)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = s.tc.SetRates(data)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	return
+}
+
+func (s *Server) UpdateTariffRatesHandler(c *gin.Context) {
+	if s.tc == nil {
+		c.String(http.StatusNotFound, "no tariff configured")
+		return
+	}
+
+	err := s.tc.UpdateRates()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	return
+}
+
+func (s *Server) GetTariffRatesHandler(c *gin.Context) {
+	if s.tc == nil {
+		c.String(http.StatusNotFound, "no tariff configured")
+		return
+	}
+
+	rates, err := s.tc.GetRates()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+	return
+}
+
 //func (s *Server) SubmitSolarActualsHandler(c *gin.Context) {
 //	err := s.SubmitSolarActuals()
 //	if err != nil {
@@ -321,3 +788,54 @@ func (s *Server) GetForecastDataHandler(c *gin.Context) {
 //		return
 //	}
 //}
+
+// BacktestHandler replays the days query param days from the from query param, 7 days up to yesterday by default.
+// A JSON config in the body is applied over the current config for the replay.
+func (s *Server) BacktestHandler(c *gin.Context) {
+	if s.bt == nil {
+		c.String(http.StatusNotFound, "no forecast history kept, set CACHE_DIR")
+		return
+	}
+
+	days := 7
+	if ds := c.Query("days"); ds != "" {
+		v, err := strconv.Atoi(ds)
+		if err != nil || v < 1 || v > backtest.MaxDays {
+			c.String(http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", backtest.MaxDays))
+			return
+		}
+		days = v
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -days)
+	if fs := c.Query("from"); fs != "" {
+		d, err := time.ParseInLocation(dateFormat, fs, time.Local)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		from = d
+	}
+
+	config := *s.f.GetConfig()
+	err := c.ShouldBindJSON(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		badRequest(c, "", err)
+		return
+	}
+
+	var ve forecaster.ValidationError
+	if errors.As(config.Validate(), &ve) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": ve})
+		return
+	}
+
+	result, err := s.bt.Run(config, from, days)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
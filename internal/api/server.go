@@ -1,49 +1,139 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/jakekeeys/givforecast/internal/givenergy"
 
+	"github.com/jakekeeys/givforecast/internal/accuracy"
+	"github.com/jakekeeys/givforecast/internal/backtest"
 	"github.com/jakekeeys/givforecast/internal/forecaster"
-	"github.com/jakekeeys/givforecast/internal/givtcp"
+	"github.com/jakekeeys/givforecast/internal/inverter"
 	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/tariff"
 )
 
 const (
 	dateFormat = "2006-01-02"
 	timeFormat = "2006-01-02T15:04"
+
+	freshForecastAge = 15 * time.Minute
 )
 
 type Server struct {
-	f     *forecaster.Forecaster
-	sc    *solcast.Client
-	gtcpc *givtcp.Client
-	gec   *givenergy.Client
+	f   *forecaster.Forecaster
+	sc  forecaster.SolarForecastProvider
+	ic  inverter.Controller
+	gec *givenergy.Client
+	tc  *tariff.Client
+	scr *solcast.Refresher
+	h   *solcast.History
+	at  *accuracy.Tracker
+	bt  *backtest.Backtester
+
+	mm               sync.Mutex
+	modeTimers       []*time.Timer // battery mode changes scheduled for the discharge slots
+	dischargeWritten bool          // the discharge slots were last written for export windows
 }
 
-func NewServer(f *forecaster.Forecaster, sc *solcast.Client, gtcpc *givtcp.Client, gec *givenergy.Client) *Server {
+// NewServer creates a server, tc may be nil when no dynamic tariff is configured, scr when solcast isn't auto refreshed
+// and h, at and bt when forecast history isn't kept
+func NewServer(f *forecaster.Forecaster, sc forecaster.SolarForecastProvider, ic inverter.Controller, gec *givenergy.Client, tc *tariff.Client, scr *solcast.Refresher, h *solcast.History, at *accuracy.Tracker, bt *backtest.Backtester) *Server {
 	return &Server{
-		f:     f,
-		sc:    sc,
-		gtcpc: gtcpc,
-		gec:   gec,
+		f:   f,
+		sc:  sc,
+		ic:  ic,
+		gec: gec,
+		tc:  tc,
+		scr: scr,
+		h:   h,
+		at:  at,
+		bt:  bt,
 	}
 }
 
-func (s *Server) UpdateChargeTarget() error {
+// updateSolarForecast refreshes the solar forecast unless it was refreshed recently, carrying on with the
+// cached forecast when the solcast budget is spent
+func (s *Server) updateSolarForecast() error {
+	fcd, err := s.sc.GetForecast()
+	if err == nil && time.Since(fcd.UpdatedAt) < freshForecastAge {
+		println(fmt.Sprintf("using solar forecast from %s", fcd.UpdatedAt.Local().Format(time.Kitchen)))
+		return nil
+	}
+
 	println("updating solar forecasts")
-	err := s.sc.UpdateForecast()
+	err = s.sc.UpdateForecast()
+	if errors.Is(err, solcast.ErrBudgetExhausted) {
+		println(err.Error())
+		return nil
+	}
+
+	return err
+}
+
+// EvaluateAccuracy compares yesterday's forecast with the measured actuals
+func (s *Server) EvaluateAccuracy() (*accuracy.Day, error) {
+	if s.at == nil {
+		return nil, errors.New("no forecast history kept, set CACHE_DIR")
+	}
+
+	now := time.Now()
+	d := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+	println(fmt.Sprintf("evaluating forecast accuracy for %s", d.Format(dateFormat)))
+	return s.at.Evaluate(d)
+}
+
+// EvaluateHindsight compares yesterday's charge target with the ideal target given what was measured
+func (s *Server) EvaluateHindsight() (*accuracy.Hindsight, error) {
+	if s.at == nil {
+		return nil, errors.New("no forecast history kept, set CACHE_DIR")
+	}
+
+	now := time.Now()
+	d := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+	println(fmt.Sprintf("evaluating charge target hindsight for %s", d.Format(dateFormat)))
+	return s.at.Hindsight(d)
+}
+
+// LearnBias relearns the solar bias factors from the accuracy of the days days up to yesterday
+func (s *Server) LearnBias(days int) (*forecaster.BiasFactors, error) {
+	if s.at == nil {
+		return nil, errors.New("no forecast history kept, set CACHE_DIR")
+	}
+
+	now := time.Now()
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+	factors, err := s.at.LearnBias(yesterday, days)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.f.SetBiasFactors(factors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &factors, nil
+}
+
+func (s *Server) UpdateChargeTarget() error {
+	err := s.updateSolarForecast()
 	if err != nil {
 		return err
 	}
 
-	//println("updating consumption averages")
-	//err = s.gec.UpdateConsumptionAverages()
-	//if err != nil {
-	//	return err
-	//}
+	if s.f.GetConfig().AvgConsumptionKw == 0 {
+		println("updating consumption averages")
+		err = s.gec.UpdateConsumptionAverages()
+		if err != nil {
+			return err
+		}
+	}
 
 	now := time.Now().UTC()
 	d := time.Date(now.Local().Year(), now.Local().Month(), now.Local().Day(), 0, 0, 0, 0, time.Local)
@@ -53,85 +143,277 @@ func (s *Server) UpdateChargeTarget() error {
 		return err
 	}
 
+	if lad := s.f.GetConfig().LookaheadDays; lad > 0 {
+		println(fmt.Sprintf("looking ahead %d days", lad))
+		fr, err := s.f.ForecastRange(d, lad+1)
+		if err != nil {
+			return err
+		}
+		forecast = fr.Days[0]
+	}
+
 	t := int(forecast.RecommendedChargeTarget)
-	println(fmt.Sprintf("setting charge target to %d", t))
-	// todo make this an interface supported by either givtcp or gecloud
+	println(fmt.Sprintf("charge target %d%% based on solar forecast from %s", t, forecast.ForecastRevision.Local().Format(time.RFC3339)))
 
-	if s.f.GetConfig().AutomaticTargetsEnabled {
-		maxRetries := 10
-		for i := 1; i < maxRetries+1; i++ {
-			err := s.gec.SetChargeUpperLimit(t)
-			if err != nil {
-				println(fmt.Errorf("setting charge target failed, attempt %d/%d waiting and retrying, err: %w", i, maxRetries, err).Error())
-				time.Sleep(time.Second * time.Duration(i*3))
-			} else {
-				break
-			}
+	if !s.f.GetConfig().AutomaticTargetsEnabled {
+		return nil
+	}
 
-			if i == maxRetries {
+	slots := s.f.InverterSlots(forecast)
+	if len(slots) > 1 || s.tc != nil {
+		var supported int
+		var shared bool
+		err = withRetries("getting charge slot support", func() error {
+			supported, err = s.ic.ChargeSlotCount()
+			if err != nil {
 				return err
 			}
+			shared, err = s.ic.SharedChargeTarget()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(slots) > supported || shared {
+			println(fmt.Sprintf("fitting %d charge slots to the %d the inverter supports, shared target %t", len(slots), supported, shared))
+			slots = forecaster.FitSlots(slots, supported, shared)
 		}
+
+		var chargeSlots []inverter.Slot
+		for _, slot := range slots {
+			println(fmt.Sprintf("setting charge slot %s-%s to %.0f%%", slot.Start.Format("15:04"), slot.End.Format("15:04"), slot.TargetSOC))
+			chargeSlots = append(chargeSlots, inverter.Slot{
+				Start:     slot.Start,
+				End:       slot.End,
+				TargetSOC: int(slot.TargetSOC),
+			})
+		}
+
+		err = withRetries("setting charge slots", func() error {
+			return s.ic.SetChargeSlots(chargeSlots)
+		})
+		if err == nil && len(chargeSlots) > 0 {
+			s.gec.ChargeSlotWritten(givenergy.Slot(chargeSlots[0]))
+		}
+	} else {
+		println(fmt.Sprintf("setting charge target to %d", t))
+		err = withRetries("setting charge target", func() error {
+			return s.ic.SetChargeTarget(t)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// only targets that made it to the inverter are recorded, accuracy is measured against them
+	if s.h != nil {
+		consumptionW := make(map[time.Time]float64)
+		for _, fc := range forecast.Forecasts {
+			consumptionW[fc.PeriodEnd.UTC()] = fc.ConsumptionW
+		}
+
+		err = s.h.RecordChargeTarget(solcast.ChargeTarget{
+			SetAt:        time.Now().UTC(),
+			Date:         forecast.Date,
+			Revision:     forecast.ForecastRevision,
+			TargetSOC:    forecast.RecommendedChargeTarget,
+			ConsumptionW: consumptionW,
+		})
+		if err != nil {
+			println(fmt.Errorf("error recording charge target: %w", err).Error())
+		}
+	}
+
+	// without export windows the inverter's discharge slots and battery mode are left to the user, slots written for
+	// windows since startup are cleared once so they don't linger
+	exporting := len(s.f.GetConfig().ExportWindows) > 0
+	s.mm.Lock()
+	written := s.dischargeWritten
+	s.mm.Unlock()
+	if !exporting && !written {
+		return nil
+	}
+
+	var dischargeSlots []inverter.Slot
+	for _, slot := range forecast.ExportSlots {
+		println(fmt.Sprintf("setting discharge slot %s-%s to %.0f%%", slot.Start.Format("15:04"), slot.End.Format("15:04"), slot.TargetSOC))
+		dischargeSlots = append(dischargeSlots, inverter.Slot{
+			Start:     slot.Start,
+			End:       slot.End,
+			TargetSOC: int(math.Ceil(slot.TargetSOC)),
+		})
+	}
+
+	err = withRetries("setting discharge slots", func() error {
+		return s.ic.SetDischargeSlots(dischargeSlots)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mm.Lock()
+	s.dischargeWritten = exporting
+	s.mm.Unlock()
+
+	return s.scheduleBatteryModes(dischargeSlots)
+}
+
+// scheduleBatteryModes switches the battery to timed export for the discharge slots and back to eco after them, so
+// the battery follows demand for the rest of the day as the simulation assumes. Modes scheduled for earlier slots are
+// cancelled.
+func (s *Server) scheduleBatteryModes(slots []inverter.Slot) error {
+	s.mm.Lock()
+	defer s.mm.Unlock()
+
+	for _, timer := range s.modeTimers {
+		timer.Stop()
+	}
+	s.modeTimers = nil
+
+	// contiguous slots are exported through without switching back in between
+	var periods []inverter.Slot
+	for _, slot := range slots {
+		if len(periods) > 0 && !periods[len(periods)-1].End.Before(slot.Start) {
+			periods[len(periods)-1].End = slot.End
+			continue
+		}
+		periods = append(periods, slot)
+	}
+
+	now := time.Now()
+	mode := inverter.ModeEco
+	for _, period := range periods {
+		if !now.Before(period.Start) && now.Before(period.End) {
+			mode = inverter.ModeTimedExport
+		}
+		if period.Start.After(now) {
+			s.modeTimers = append(s.modeTimers, time.AfterFunc(period.Start.Sub(now), func() {
+				_ = s.setBatteryMode(inverter.ModeTimedExport)
+			}))
+		}
+		if period.End.After(now) {
+			s.modeTimers = append(s.modeTimers, time.AfterFunc(period.End.Sub(now), func() {
+				_ = s.setBatteryMode(inverter.ModeEco)
+			}))
+		}
+	}
+
+	return s.setBatteryMode(mode)
+}
+
+// setBatteryMode sets the battery mode, controllers without modes, e.g. for the ems whose export slots apply by
+// themselves, are skipped
+func (s *Server) setBatteryMode(mode inverter.Mode) error {
+	println(fmt.Sprintf("setting battery mode %s", mode))
+	err := withRetries("setting battery mode", func() error {
+		return s.ic.SetBatteryMode(mode)
+	})
+	if errors.Is(err, inverter.ErrNotSupported) {
+		return nil
+	}
+	if err != nil {
+		println(fmt.Errorf("err setting battery mode %s: %w", mode, err).Error())
+	}
+
+	return err
+}
+
+// Replan re-simulates the current forecast from the battery's live state of charge
+func (s *Server) Replan() (*forecaster.ForecastDay, error) {
+	soc, err := s.ic.GetSOC()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	println(fmt.Sprintf("replanning from %.0f%% at %s", soc, now.Format(timeFormat)))
+	return s.f.Replan(now, soc)
+}
+
+// withRetries calls fn until it succeeds, backing off between attempts. Only transient errors are retried, a
+// rejected request would fail the same way again.
+func withRetries(action string, fn func() error) error {
+	maxRetries := 10
+	for i := 1; i < maxRetries+1; i++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if i == maxRetries || !inverter.Transient(err) {
+			return err
+		}
+
+		println(fmt.Errorf("%s failed, attempt %d/%d waiting and retrying, err: %w", action, i, maxRetries, err).Error())
+		time.Sleep(time.Second * time.Duration(i*3))
 	}
 
 	return nil
 }
 
-//func (s *Server) SubmitSolarActuals() error {
-//	println("submitting solar readings to solcast")
-//	now := time.Now().UTC()
-//	yesterday := time.Date(now.Local().Year(), now.Local().Month(), now.Local().Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
-//	day, err := s.gec.PlantChartDay(yesterday)
-//	if err != nil {
-//		return err
-//	}
-//
-//	solarActuals := map[time.Time]float64{}
-//	for _, measurement := range day.Data {
-//		t, err := time.Parse("2006-01-02 15:04:05", measurement.Time)
-//		if err != nil {
-//			return err
-//		}
-//
-//		t = roundUpTime(t, time.Minute*10)
-//		if v, ok := solarActuals[t]; ok {
-//			solarActuals[t] = (v + measurement.Ppv) / 2
-//		} else {
-//			solarActuals[t] = measurement.Ppv
-//		}
-//	}
-//
-//	var measurements []solcast.Measurement
-//	for k, v := range solarActuals {
-//		if v < 50 {
-//			continue
-//		}
-//
-//		measurements = append(measurements, solcast.Measurement{
-//			PeriodEnd:  k,
-//			Period:     "PT10M",
-//			TotalPower: v / 1000,
-//		})
-//	}
-//
-//	sort.Slice(measurements, func(i, j int) bool {
-//		return measurements[i].PeriodEnd.Before(measurements[j].PeriodEnd)
-//	})
-//
-//	err = s.sc.SubmitMeasurements(&solcast.SubmitMeasurementsRequest{Measurements: measurements})
-//	if err != nil {
-//		return err
-//	}
-//
-//	return nil
-//}
-
-func roundUpTime(t time.Time, roundOn time.Duration) time.Time {
-	t = t.Round(roundOn)
-
-	if time.Since(t) >= 0 {
-		t = t.Add(roundOn)
-	}
-
-	return t
+// SubmitSolarActuals submits yesterday's measured solar generation to solcast averaged over period, PT10M or PT30M,
+// so solcast can tune the site. The outcome is recorded to the forecast history.
+func (s *Server) SubmitSolarActuals(period string) (*solcast.Submission, error) {
+	sc, ok := s.sc.(*solcast.Client)
+	if !ok {
+		return nil, errors.New("solcast is not the solar forecast provider")
+	}
+
+	now := time.Now()
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+	println(fmt.Sprintf("submitting solar readings for %s to solcast", yesterday.Format(dateFormat)))
+
+	submission := &solcast.Submission{
+		SubmittedAt: now.UTC(),
+		Date:        yesterday,
+		Period:      period,
+	}
+	err := s.submitSolarActuals(sc, submission)
+	if err != nil {
+		submission.Error = err.Error()
+	}
+
+	if s.h != nil {
+		herr := s.h.RecordSubmission(*submission)
+		if herr != nil {
+			println(fmt.Errorf("error recording solcast submission: %w", herr).Error())
+		}
+	}
+
+	return submission, err
+}
+
+func (s *Server) submitSolarActuals(sc *solcast.Client, submission *solcast.Submission) error {
+	pd, err := solcast.ParsePeriod(submission.Period)
+	if err != nil {
+		return err
+	}
+
+	actuals, err := s.gec.GetActuals(submission.Date, pd)
+	if err != nil {
+		return err
+	}
+
+	var measurements []solcast.Measurement
+	for pe, actual := range actuals {
+		if actual.SolarW < 50 {
+			continue
+		}
+
+		measurements = append(measurements, solcast.Measurement{
+			PeriodEnd:  pe,
+			Period:     submission.Period,
+			TotalPower: actual.SolarW / 1000,
+		})
+	}
+	if len(measurements) == 0 {
+		return errors.New("no solar generation measured")
+	}
+
+	sort.Slice(measurements, func(i, j int) bool {
+		return measurements[i].PeriodEnd.Before(measurements[j].PeriodEnd)
+	})
+	submission.Measurements = len(measurements)
+
+	return sc.SubmitMeasurements(&solcast.SubmitMeasurementsRequest{Measurements: measurements})
 }
@@ -0,0 +1,135 @@
+// Package backtest replays historical days through the forecaster, setting each day's charge target from the solar
+// forecast revision available at the time and simulating it against the measured solar and consumption
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+// MaxDays caps how many days a single backtest replays
+const MaxDays = 92
+
+type Backtester struct {
+	h    *solcast.History
+	gec  *givenergy.Client
+	opts []forecaster.Option
+	// actuals returns the measured solar and consumption for a day, the givenergy cloud's by default
+	actuals func(d time.Time, period time.Duration) (map[time.Time]givenergy.Actual, error)
+}
+
+// NewBacktester creates a backtester replaying forecasts from h against actuals from gec, opts are applied to the
+// forecaster built for each day
+func NewBacktester(h *solcast.History, gec *givenergy.Client, opts ...forecaster.Option) *Backtester {
+	return &Backtester{
+		h:       h,
+		gec:     gec,
+		opts:    opts,
+		actuals: gec.GetActuals,
+	}
+}
+
+type Result struct {
+	From            time.Time
+	Config          forecaster.Config
+	GridImportKwh   float64
+	GridExportKwh   float64
+	WastedSolarKwh  float64
+	ReserveHits     int
+	ReserveHitDays  int // days the battery hit its reserve at least once
+	ImportCostPence float64
+	Days            []*forecaster.ReplayDay
+	Skipped         map[string]string // reason each day without a forecast revision or actuals was skipped, keyed by date
+}
+
+// Run replays days days from the local day of from with config, chaining each day from the previous day's end of
+// day state of charge
+func (b *Backtester) Run(config forecaster.Config, from time.Time, days int) (*Result, error) {
+	if days < 1 || days > MaxDays {
+		return nil, fmt.Errorf("backtest must cover between 1 and %d days", MaxDays)
+	}
+
+	from = time.Date(from.Local().Year(), from.Local().Month(), from.Local().Day(), 0, 0, 0, 0, time.Local)
+	result := &Result{
+		From:    from,
+		Config:  config,
+		Days:    []*forecaster.ReplayDay{},
+		Skipped: make(map[string]string),
+	}
+
+	prevEndSOC := -1.0
+	for i := 0; i < days; i++ {
+		d := from.AddDate(0, 0, i)
+		rd, err := b.day(config, d, prevEndSOC)
+		if err != nil {
+			result.Skipped[d.Format("2006-01-02")] = err.Error()
+			prevEndSOC = -1
+			continue
+		}
+		prevEndSOC = rd.EndSOC
+
+		result.GridImportKwh = result.GridImportKwh + rd.GridImportKwh
+		result.GridExportKwh = result.GridExportKwh + rd.GridExportKwh
+		result.WastedSolarKwh = result.WastedSolarKwh + rd.WastedSolarKwh
+		result.ImportCostPence = result.ImportCostPence + rd.ImportCostPence
+		result.ReserveHits = result.ReserveHits + rd.ReserveHits
+		if rd.ReserveHits > 0 {
+			result.ReserveHitDays++
+		}
+		result.Days = append(result.Days, rd)
+	}
+
+	return result, nil
+}
+
+func (b *Backtester) day(config forecaster.Config, d time.Time, prevEndSOC float64) (*forecaster.ReplayDay, error) {
+	fcd, err := b.revision(config, d)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := append([]forecaster.Option{}, b.opts...)
+	// the day is planned against the rates it had, as the target would have been set at the time
+	f := forecaster.New(forecaster.NewStaticForecast(fcd), b.gec, append(opts, forecaster.WithConfig(&config))...).Past(d)
+	fd, err := f.Forecast(d)
+	if err != nil {
+		return nil, err
+	}
+
+	actuals, err := b.actuals(d, 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if len(actuals) == 0 {
+		return nil, errors.New("no actuals")
+	}
+
+	return f.Replay(fd, prevEndSOC, actuals)
+}
+
+// revision picks the last forecast revision made before the charge target for d would have been set, the start of
+// the primary charge window or midnight when there isn't one
+func (b *Backtester) revision(config forecaster.Config, d time.Time) (*solcast.ForecastData, error) {
+	dh, err := b.h.Day(d)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := d
+	if len(config.ChargeWindows) > 0 {
+		cutoff, _ = config.ChargeWindows[0].On(d)
+	}
+
+	for i := len(dh.Revisions) - 1; i >= 0; i-- {
+		if dh.Revisions[i].UpdatedAt.Before(cutoff) {
+			return b.h.Get(dh.Revisions[i].UpdatedAt)
+		}
+	}
+
+	return nil, errors.New("no forecast revision before the charge target was due")
+}
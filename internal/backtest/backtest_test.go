@@ -0,0 +1,98 @@
+package backtest
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/store"
+	"github.com/jakekeeys/givforecast/internal/tariff"
+)
+
+// fakeRates has the rates recorded for past days but only today's as the current rates, as the tariff client does
+type fakeRates struct {
+	recorded tariff.RateData
+}
+
+func (r *fakeRates) GetRates() (*tariff.RateData, error) {
+	return &tariff.RateData{Rates: []tariff.Rate{}}, nil
+}
+
+func (r *fakeRates) GetRatesBetween(from, to time.Time) (*tariff.RateData, error) {
+	rd := &tariff.RateData{Rates: []tariff.Rate{}}
+	for _, rate := range r.recorded.Rates {
+		if !rate.ValidFrom.Before(from) && rate.ValidFrom.Before(to) {
+			rd.Rates = append(rd.Rates, rate)
+		}
+	}
+	return rd, nil
+}
+
+func TestRunTariff(t *testing.T) {
+	st, err := store.Open(path.Join(t.TempDir(), "givforecast.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer st.Close()
+
+	d := time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)
+
+	// no solar so the day has to be grid charged, cheaply before 04:00
+	h := solcast.NewHistory(st)
+	fcd := solcast.ForecastData{UpdatedAt: d.Add(-2 * time.Hour).UTC()}
+	rates := &fakeRates{}
+	actuals := make(map[time.Time]givenergy.Actual)
+	for pe := d.Add(30 * time.Minute); !pe.After(d.AddDate(0, 0, 1)); pe = pe.Add(30 * time.Minute) {
+		fcd.Forecasts = append(fcd.Forecasts, solcast.Forecast{PeriodEnd: pe.UTC(), Period: "PT30M"})
+
+		pence := 30.0
+		if pe.Sub(d) <= 4*time.Hour {
+			pence = 5
+		}
+		rates.recorded.Rates = append(rates.recorded.Rates, tariff.Rate{
+			ValueIncVat: pence,
+			ValidFrom:   pe.Add(-30 * time.Minute).UTC(),
+			ValidTo:     pe.UTC(),
+		})
+
+		actuals[pe.UTC()] = givenergy.Actual{ConsumptionW: 500}
+	}
+	err = h.Record(fcd)
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	b := NewBacktester(h, nil, forecaster.WithTariff(rates))
+	b.actuals = func(_ time.Time, _ time.Duration) (map[time.Time]givenergy.Actual, error) {
+		return actuals, nil
+	}
+
+	config := forecaster.DefaultConfig()
+	config.AvgConsumptionKw = 0.5
+	result, err := b.Run(config, d, 1)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.Days) != 1 {
+		t.Fatalf("replayed %d days, want 1, skipped %v", len(result.Days), result.Skipped)
+	}
+
+	day := result.Days[0]
+	if len(day.ChargeSlots) == 0 {
+		t.Fatal("no charge slots planned")
+	}
+	for _, slot := range day.ChargeSlots {
+		if slot.RatePence != 5 {
+			t.Errorf("charge slot %s-%s at %vp, want only the 5p periods", slot.Start.Format("15:04"), slot.End.Format("15:04"), slot.RatePence)
+		}
+	}
+	if day.TargetSOC <= config.BatteryLowerReserve {
+		t.Errorf("target %v%%, want above the %v%% reserve", day.TargetSOC, config.BatteryLowerReserve)
+	}
+	if day.ImportCostPence <= 0 {
+		t.Errorf("import cost %vp, want the day priced at its recorded rates", day.ImportCostPence)
+	}
+}
@@ -0,0 +1,223 @@
+// Package config loads the service config from an optional YAML file, with environment variables overriding the file
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+)
+
+type Config struct {
+	Port       int        `yaml:"port"`
+	CacheDir   string     `yaml:"cache_dir"`
+	Timezone   string     `yaml:"timezone"`
+	Solar      Solar      `yaml:"solar"`
+	Solcast    Solcast    `yaml:"solcast"`
+	GivEnergy  GivEnergy  `yaml:"givenergy"`
+	GivTCP     GivTCP     `yaml:"givtcp"`
+	Inverter   Inverter   `yaml:"inverter"`
+	Tariff     Tariff     `yaml:"tariff"`
+	Forecaster Forecaster `yaml:"forecaster"`
+	Cron       Cron       `yaml:"cron"`
+}
+
+type Solar struct {
+	Provider            string  `yaml:"provider"` // solcast, forecastsolar or openmeteo
+	Latitude            float64 `yaml:"latitude"`
+	Longitude           float64 `yaml:"longitude"`
+	Declination         float64 `yaml:"declination"`
+	Azimuth             float64 `yaml:"azimuth"`
+	Kwp                 float64 `yaml:"kwp"`
+	ForecastSolarAPIKey string  `yaml:"forecast_solar_api_key"`
+}
+
+type Solcast struct {
+	APIKey            string   `yaml:"api_key"`
+	ResourceIDs       []string `yaml:"resource_ids"`
+	DailyLimit        int      `yaml:"daily_limit"`
+	AutoRefresh       bool     `yaml:"auto_refresh"`
+	MeasurementPeriod string   `yaml:"measurement_period"`
+}
+
+type GivEnergy struct {
+	APIKey                 string   `yaml:"api_key"`
+	Serials                []string `yaml:"serials"`
+	EMS                    bool     `yaml:"ems"`
+	ConsumptionHistoryDays int      `yaml:"consumption_history_days"`
+}
+
+type GivTCP struct {
+	URL string `yaml:"url"`
+}
+
+type Inverter struct {
+	Controller    string `yaml:"controller"`     // cloud, givtcp, modbus or failover from givtcp to cloud
+	ModbusAddress string `yaml:"modbus_address"` // host of the inverter's data adapter, port 8899 unless given
+}
+
+type Tariff struct {
+	Source string `yaml:"source"`
+}
+
+// Forecaster is the forecaster config, windows use the same format as their environment variables,
+// e.g. 00:30-04:30@80 for a charge window to 80%.
+// The battery and inverter parameters discovered from the givenergy cloud apply to the fields that aren't set.
+type Forecaster struct {
+	StorageCapacityKwh      *float64 `yaml:"storage_capacity_kwh"` // discovered
+	InverterEfficiency      float64  `yaml:"inverter_efficiency"`
+	ChargeWindows           string   `yaml:"charge_windows"` // discovered
+	ChargeWindowsLocal      bool     `yaml:"charge_windows_local"`
+	BatteryLowerReserve     *float64 `yaml:"battery_lower_reserve"` // discovered
+	BatteryUpperReserve     float64  `yaml:"battery_upper_reserve"`
+	MaxChargeKw             *float64 `yaml:"max_charge_kw"`    // discovered
+	MaxDischargeKw          *float64 `yaml:"max_discharge_kw"` // discovered
+	AvgConsumptionKw        float64  `yaml:"avg_consumption_kw"`
+	AutomaticTargetsEnabled bool     `yaml:"automatic_targets_enabled"`
+	RiskAppetite            float64  `yaml:"risk_appetite"`
+	LookaheadDays           int      `yaml:"lookahead_days"`
+	ExportLimitKw           float64  `yaml:"export_limit_kw"`
+	ExportWindows           string   `yaml:"export_windows"`
+	ExportWindowsLocal      bool     `yaml:"export_windows_local"`
+	BiasCorrectionEnabled   bool     `yaml:"bias_correction_enabled"`
+}
+
+// Cron schedules in standard cron format evaluated in UTC, empty disables the job
+type Cron struct {
+	UpdateTarget string `yaml:"update_target"`
+	Replan       string `yaml:"replan"`
+	UpdateTariff string `yaml:"update_tariff"`
+	Accuracy     string `yaml:"accuracy"`
+	Hindsight    string `yaml:"hindsight"`
+	SubmitSolar  string `yaml:"submit_solar"`
+	Discovery    string `yaml:"discovery"`
+}
+
+func Default() *Config {
+	fc := forecaster.DefaultConfig()
+	return &Config{
+		Port: 8080,
+		Solar: Solar{
+			Provider: "solcast",
+		},
+		Solcast: Solcast{
+			DailyLimit:        10,
+			MeasurementPeriod: "PT10M",
+		},
+		GivEnergy: GivEnergy{
+			ConsumptionHistoryDays: 14,
+		},
+		GivTCP: GivTCP{
+			URL: "http://givtcp:80",
+		},
+		Inverter: Inverter{
+			Controller: "cloud",
+		},
+		Forecaster: Forecaster{
+			InverterEfficiency:      fc.InverterEfficiency,
+			BatteryUpperReserve:     fc.BatteryUpperReserve,
+			AvgConsumptionKw:        fc.AvgConsumptionKw,
+			AutomaticTargetsEnabled: fc.AutomaticTargetsEnabled,
+			RiskAppetite:            fc.RiskAppetite,
+			LookaheadDays:           fc.LookaheadDays,
+			ExportLimitKw:           fc.ExportLimitKw,
+			BiasCorrectionEnabled:   fc.BiasCorrectionEnabled,
+		},
+	}
+}
+
+// Load reads the config from the defaults, then the YAML file at path if it's set, then the environment
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+
+		err = yaml.UnmarshalStrict(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding config file: %w", err)
+		}
+	}
+
+	err := c.fromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.ForecasterConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ForecasterConfig converts the forecaster section into a validated forecaster config, d may be nil when nothing has
+// been discovered
+func (c *Config) ForecasterConfig(d *givenergy.Discovery) (forecaster.Config, error) {
+	fc := forecaster.DefaultConfig()
+	if d != nil {
+		discovered(&fc.StorageCapacityKwh, d.StorageCapacityKwh)
+		discovered(&fc.BatteryLowerReserve, d.BatteryReserve)
+		discovered(&fc.MaxChargeKw, d.MaxChargeKw)
+		discovered(&fc.MaxDischargeKw, d.MaxDischargeKw)
+		if d.ChargeWindow != "" {
+			cws, err := forecaster.ParseChargeWindows(d.ChargeWindow, true)
+			if err != nil {
+				return fc, fmt.Errorf("error parsing discovered charge window: %w", err)
+			}
+			fc.ChargeWindows = cws
+		}
+	}
+
+	set(&fc.StorageCapacityKwh, c.Forecaster.StorageCapacityKwh)
+	set(&fc.BatteryLowerReserve, c.Forecaster.BatteryLowerReserve)
+	set(&fc.MaxChargeKw, c.Forecaster.MaxChargeKw)
+	set(&fc.MaxDischargeKw, c.Forecaster.MaxDischargeKw)
+	fc.InverterEfficiency = c.Forecaster.InverterEfficiency
+	fc.BatteryUpperReserve = c.Forecaster.BatteryUpperReserve
+	fc.AvgConsumptionKw = c.Forecaster.AvgConsumptionKw
+	fc.AutomaticTargetsEnabled = c.Forecaster.AutomaticTargetsEnabled
+	fc.RiskAppetite = c.Forecaster.RiskAppetite
+	fc.LookaheadDays = c.Forecaster.LookaheadDays
+	fc.ExportLimitKw = c.Forecaster.ExportLimitKw
+	fc.BiasCorrectionEnabled = c.Forecaster.BiasCorrectionEnabled
+
+	if c.Forecaster.ChargeWindows != "" {
+		cws, err := forecaster.ParseChargeWindows(c.Forecaster.ChargeWindows, c.Forecaster.ChargeWindowsLocal)
+		if err != nil {
+			return fc, fmt.Errorf("error parsing charge windows: %w", err)
+		}
+		fc.ChargeWindows = cws
+	}
+
+	if c.Forecaster.ExportWindows != "" {
+		ews, err := forecaster.ParseExportWindows(c.Forecaster.ExportWindows, c.Forecaster.ExportWindowsLocal)
+		if err != nil {
+			return fc, fmt.Errorf("error parsing export windows: %w", err)
+		}
+		fc.ExportWindows = ews
+	}
+
+	return fc, fc.Validate()
+}
+
+// discovered applies a discovered value, zero values weren't discovered
+func discovered(v *float64, d float64) {
+	if d > 0 {
+		*v = d
+	}
+}
+
+// set applies a value set in the config file or environment
+func set(v *float64, s *float64) {
+	if s != nil {
+		*v = *s
+	}
+}
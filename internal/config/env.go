@@ -0,0 +1,157 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fromEnv overrides the config with the environment variables that are set
+func (c *Config) fromEnv() error {
+	e := &env{}
+
+	e.int("PORT", &c.Port)
+	e.string("CACHE_DIR", &c.CacheDir)
+	e.string("TZ_LOCATION", &c.Timezone)
+
+	e.string("SOLAR_PROVIDER", &c.Solar.Provider)
+	e.float("SOLAR_LATITUDE", &c.Solar.Latitude)
+	e.float("SOLAR_LONGITUDE", &c.Solar.Longitude)
+	e.float("SOLAR_DECLINATION", &c.Solar.Declination)
+	e.float("SOLAR_AZIMUTH", &c.Solar.Azimuth)
+	e.float("SOLAR_KWP", &c.Solar.Kwp)
+	e.string("FORECAST_SOLAR_API_KEY", &c.Solar.ForecastSolarAPIKey)
+
+	e.string("SOLCAST_API_KEY", &c.Solcast.APIKey)
+	e.list("SOLCAST_RESOURCE_ID", &c.Solcast.ResourceIDs)
+	e.int("SOLCAST_DAILY_LIMIT", &c.Solcast.DailyLimit)
+	e.bool("SOLCAST_AUTO_REFRESH", &c.Solcast.AutoRefresh)
+	e.string("SOLCAST_MEASUREMENT_PERIOD", &c.Solcast.MeasurementPeriod)
+
+	e.string("GIVENERGY_API_KEY", &c.GivEnergy.APIKey)
+	e.list("GIVENERGY_SERIALS", &c.GivEnergy.Serials)
+	e.bool("GIVENERGY_EMS", &c.GivEnergy.EMS)
+	e.int("CONSUMPTION_HISTORY_DAYS", &c.GivEnergy.ConsumptionHistoryDays)
+
+	e.string("GIVTCP_URL", &c.GivTCP.URL)
+	e.string("INVERTER_CONTROLLER", &c.Inverter.Controller)
+	e.string("INVERTER_MODBUS_ADDRESS", &c.Inverter.ModbusAddress)
+
+	e.string("TARIFF_SOURCE", &c.Tariff.Source)
+
+	e.optionalFloat("STORAGE_CAPACITY_KWH", &c.Forecaster.StorageCapacityKwh)
+	e.float("INVERTER_EFFICIENCY", &c.Forecaster.InverterEfficiency)
+	e.string("AC_CHARGE_WINDOWS", &c.Forecaster.ChargeWindows)
+	e.bool("AC_CHARGE_WINDOWS_LOCAL", &c.Forecaster.ChargeWindowsLocal)
+	e.optionalFloat("BATTERY_LOWER_RESERVE", &c.Forecaster.BatteryLowerReserve)
+	e.float("BATTERY_UPPER_RESERVE", &c.Forecaster.BatteryUpperReserve)
+	e.optionalFloat("MAX_CHARGE_KW", &c.Forecaster.MaxChargeKw)
+	e.optionalFloat("MAX_DISCHARGE_KW", &c.Forecaster.MaxDischargeKw)
+	e.float("AVG_CONS_KWH", &c.Forecaster.AvgConsumptionKw)
+	e.bool("AUTOMATIC_TARGETS_ENABLED", &c.Forecaster.AutomaticTargetsEnabled)
+	e.float("RISK_APPETITE", &c.Forecaster.RiskAppetite)
+	e.int("LOOKAHEAD_DAYS", &c.Forecaster.LookaheadDays)
+	e.float("EXPORT_LIMIT_KW", &c.Forecaster.ExportLimitKw)
+	e.string("EXPORT_WINDOWS", &c.Forecaster.ExportWindows)
+	e.bool("EXPORT_WINDOWS_LOCAL", &c.Forecaster.ExportWindowsLocal)
+	e.bool("BIAS_CORRECTION_ENABLED", &c.Forecaster.BiasCorrectionEnabled)
+
+	e.string("UPDATE_TARGET_CRON", &c.Cron.UpdateTarget)
+	e.string("REPLAN_CRON", &c.Cron.Replan)
+	e.string("UPDATE_TARIFF_CRON", &c.Cron.UpdateTariff)
+	e.string("ACCURACY_CRON", &c.Cron.Accuracy)
+	e.string("HINDSIGHT_CRON", &c.Cron.Hindsight)
+	e.string("SUBMIT_SOLAR_CRON", &c.Cron.SubmitSolar)
+	e.string("DISCOVERY_CRON", &c.Cron.Discovery)
+
+	return e.err()
+}
+
+// env collects the errors parsing environment variables so they can all be reported at once
+type env struct {
+	errs []error
+}
+
+func (e *env) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+
+	var msgs []string
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, ", "))
+}
+
+func (e *env) lookup(key string) (string, bool) {
+	v, ok := os.LookupEnv(key)
+	return strings.TrimSpace(v), ok && strings.TrimSpace(v) != ""
+}
+
+func (e *env) string(key string, v *string) {
+	if s, ok := e.lookup(key); ok {
+		*v = s
+	}
+}
+
+func (e *env) bool(key string, v *bool) {
+	if s, ok := e.lookup(key); ok {
+		*v = s == "true"
+	}
+}
+
+func (e *env) int(key string, v *int) {
+	s, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("err parsing %s: %w", key, err))
+		return
+	}
+	*v = i
+}
+
+func (e *env) float(key string, v *float64) {
+	s, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("err parsing %s: %w", key, err))
+		return
+	}
+	*v = f
+}
+
+// optionalFloat sets v only when the variable is set, leaving it nil otherwise
+func (e *env) optionalFloat(key string, v **float64) {
+	if _, ok := e.lookup(key); !ok {
+		return
+	}
+
+	var f float64
+	e.float(key, &f)
+	*v = &f
+}
+
+// list parses a comma separated list
+func (e *env) list(key string, v *[]string) {
+	s, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		items = append(items, strings.TrimSpace(item))
+	}
+	*v = items
+}
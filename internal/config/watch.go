@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Watch polls the file at path every interval, calling onChange with the reloaded config whenever it's modified.
+// A config that fails to load is logged and skipped.
+func Watch(path string, interval time.Duration, onChange func(c *Config)) {
+	go func() {
+		var modTime time.Time
+		if fi, err := os.Stat(path); err == nil {
+			modTime = fi.ModTime()
+		}
+
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = fi.ModTime()
+
+			c, err := Load(path)
+			if err != nil {
+				println(fmt.Errorf("error reloading config, keeping the previous config: %w", err).Error())
+				continue
+			}
+
+			onChange(c)
+		}
+	}()
+}
+
+// Changed lists the top level sections and fields of c that differ from prev by their yaml name
+func (c *Config) Changed(prev *Config) []string {
+	var changed []string
+	cv, pv := reflect.ValueOf(*c), reflect.ValueOf(*prev)
+	for i := 0; i < cv.NumField(); i++ {
+		if !reflect.DeepEqual(cv.Field(i).Interface(), pv.Field(i).Interface()) {
+			changed = append(changed, cv.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+
+	return changed
+}
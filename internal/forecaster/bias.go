@@ -0,0 +1,36 @@
+package forecaster
+
+import (
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+// BiasFactors scale the solar estimates by local month and hour of day, indexed [month-1][hour]. Unlearned factors are 0
+// and leave the estimate unchanged.
+type BiasFactors [12][24]float64
+
+// At returns the factor for the half hour period ending at t
+func (bf *BiasFactors) At(t time.Time) float64 {
+	mid := t.Add(-15 * time.Minute).Local()
+	factor := bf[mid.Month()-1][mid.Hour()]
+	if factor == 0 {
+		return 1
+	}
+
+	return factor
+}
+
+// correct applies the learned bias factors to the estimates of a forecast period when bias correction is enabled
+func (f *Forecaster) correct(forecast solcast.Forecast) solcast.Forecast {
+	if !f.config.BiasCorrectionEnabled {
+		return forecast
+	}
+
+	factor := f.config.BiasFactors.At(forecast.PeriodEnd)
+	forecast.PvEstimate = forecast.PvEstimate * factor
+	forecast.PvEstimate10 = forecast.PvEstimate10 * factor
+	forecast.PvEstimate90 = forecast.PvEstimate90 * factor
+
+	return forecast
+}
@@ -0,0 +1,162 @@
+package forecaster
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExportWindow is a daily period the battery is force discharged to the grid in, e.g. a premium export window.
+// Only the hour and minute of Start and End are used.
+type ExportWindow struct {
+	Start     time.Time
+	End       time.Time
+	Local     bool    // times follow local time, otherwise they're UTC and don't shift with BST
+	RatePence float64 // export rate paid per kWh during the window
+}
+
+// On returns the occurrence of the window ending on the local date of d
+func (w ExportWindow) On(d time.Time) (time.Time, time.Time) {
+	return ChargeWindow{Start: w.Start, End: w.End, Local: w.Local}.On(d)
+}
+
+type ExportSlot struct {
+	Start     time.Time
+	End       time.Time
+	RatePence float64
+	Kwh       float64 // energy exported from the battery
+	TargetSOC float64 // SOC to stop discharging at
+}
+
+// ParseExportWindows parses a comma separated list of windows in the form 16:00-19:00 with an optional @rate suffix in pence, e.g. 16:00-19:00@29.5
+func ParseExportWindows(s string, local bool) ([]ExportWindow, error) {
+	var windows []ExportWindow
+	for _, ws := range strings.Split(s, ",") {
+		start, end, rate, err := parseWindow(ws)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, ExportWindow{
+			Start:     start,
+			End:       end,
+			Local:     local,
+			RatePence: rate,
+		})
+	}
+
+	return windows, nil
+}
+
+// runWithExports runs r, then for each export window in the run exports as much from the battery as possible
+// without it running down to the reserve before the end of the run
+func (f *Forecaster) runWithExports(r run) (*Simulation, error) {
+	simulation, err := f.run(r)
+	if err != nil || len(f.config.ExportWindows) == 0 {
+		return simulation, err
+	}
+
+	type occurrence struct {
+		slot    *ExportSlot
+		periods map[time.Time]float64 // share of the window's export by period end
+		maxKwh  float64
+	}
+
+	var occurrences []*occurrence
+	for _, w := range f.config.ExportWindows {
+		for d := r.from.AddDate(0, 0, -1); !d.After(r.to.AddDate(0, 0, 1)); d = d.AddDate(0, 0, 1) {
+			start, end := w.On(d)
+			if start.Before(r.from) || end.After(r.to) {
+				continue
+			}
+
+			o := &occurrence{
+				slot: &ExportSlot{
+					Start:     start.Local(),
+					End:       end.Local(),
+					RatePence: w.RatePence,
+				},
+				periods: make(map[time.Time]float64),
+			}
+			for pe := start.Truncate(30 * time.Minute).Add(30 * time.Minute); pe.Add(-30 * time.Minute).Before(end); pe = pe.Add(30 * time.Minute) {
+				overlap := minTime(pe, end).Sub(maxTime(pe.Add(-30*time.Minute), start))
+				if overlap <= 0 {
+					continue
+				}
+				o.periods[pe.UTC()] = overlap.Hours() / end.Sub(start).Hours()
+				o.maxKwh = o.maxKwh + f.config.MaxDischargeKw*overlap.Hours()
+			}
+			occurrences = append(occurrences, o)
+		}
+	}
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].slot.Start.Before(occurrences[j].slot.Start)
+	})
+
+	shortfallAfter := func(simulation *Simulation, t time.Time) float64 {
+		var shortfallKwh float64
+		for _, fc := range simulation.Forecasts {
+			if fc.PeriodEnd.After(t) {
+				shortfallKwh = shortfallKwh + fc.shortfallKwh
+			}
+		}
+		return shortfallKwh
+	}
+
+	exports := make(map[time.Time]float64)
+	r.exports = exports
+	for _, o := range occurrences {
+		baselineKwh := shortfallAfter(simulation, o.slot.Start)
+
+		// binary search the most we can export without increasing the shortfall later in the run
+		var lo, hi = 0.0, o.maxKwh
+		for i := 0; i < 16; i++ {
+			mid := (lo + hi) / 2
+			for pe, share := range o.periods {
+				exports[pe] = mid * share
+			}
+
+			trial, err := f.run(r)
+			if err != nil {
+				return nil, err
+			}
+
+			if shortfallAfter(trial, o.slot.Start) > baselineKwh+0.001 {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+
+		for pe, share := range o.periods {
+			exports[pe] = lo * share
+		}
+
+		simulation, err = f.run(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range occurrences {
+		for _, fc := range simulation.Forecasts {
+			if _, ok := o.periods[fc.PeriodEnd.UTC()]; !ok {
+				continue
+			}
+
+			o.slot.Kwh = o.slot.Kwh + fc.forcedExportKwh
+			o.slot.TargetSOC = fc.SOC
+		}
+		o.slot.TargetSOC = math.Max(o.slot.TargetSOC, f.config.BatteryLowerReserve)
+
+		if o.slot.Kwh < 0.01 {
+			continue
+		}
+
+		simulation.ExportSlots = append(simulation.ExportSlots, o.slot)
+		simulation.ExportRevenuePence = simulation.ExportRevenuePence + o.slot.Kwh*o.slot.RatePence
+	}
+
+	return simulation, nil
+}
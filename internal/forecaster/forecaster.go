@@ -1,29 +1,34 @@
 package forecaster
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
-	"os"
-	"strconv"
 	"sync"
 	"time"
 
 	"github.com/jakekeeys/givforecast/internal/givenergy"
 	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/tariff"
 )
 
 type Config struct {
 	StorageCapacityKwh      float64
 	InverterEfficiency      float64
-	ACChargeStart           time.Time
-	ACChargeEnd             time.Time
+	ChargeWindows           []ChargeWindow // the first window is the overnight charge the target is recommended for
 	BatteryLowerReserve     float64
 	MaxChargeKw             float64
 	MaxDischargeKw          float64
 	AvgConsumptionKw        float64
 	BatteryUpperReserve     float64
 	AutomaticTargetsEnabled bool
+	RiskAppetite            float64 // 0 plans against the P10 solar estimate, 0.5 the P50 and 1 the P90, values between blend
+	LookaheadDays           int     // days after the target day considered when recommending a charge target
+	ExportLimitKw           float64 // export cap agreed with the DNO (G98/G99), 0 for no limit
+	ExportWindows           []ExportWindow
+	BiasCorrectionEnabled   bool        // scale the solar estimates by BiasFactors
+	BiasFactors             BiasFactors // learned from the measured accuracy of past forecasts
 }
 
 func WithConfig(c *Config) Option {
@@ -32,111 +37,121 @@ func WithConfig(c *Config) Option {
 	}
 }
 
+// WithTariff plans grid charging against the tariff's half-hourly rates in place of the fixed AC charge window
+func WithTariff(tc RateProvider) Option {
+	return func(p *Forecaster) {
+		p.tc = tc
+	}
+}
+
 type Option func(p *Forecaster)
 
+// SolarForecastProvider supplies half hourly PV estimates in kW in the solcast forecast shape
+type SolarForecastProvider interface {
+	GetForecast() (*solcast.ForecastData, error)
+	UpdateForecast() error
+	SetForecast(fcd solcast.ForecastData) error
+}
+
+// RateProvider supplies the tariff's half hourly rates, the current ones and those recorded for past days
+type RateProvider interface {
+	GetRates() (*tariff.RateData, error)
+	GetRatesBetween(from, to time.Time) (*tariff.RateData, error)
+}
+
 type Forecaster struct {
-	sc     *solcast.Client
+	sc     SolarForecastProvider
 	gec    *givenergy.Client
+	tc     RateProvider
 	config *Config
+	replan *ForecastDay
 	m      sync.RWMutex
+	// measured consumption in W keyed by period end, replacing the estimate for the periods it covers
+	consumption map[time.Time]float64
+	configDir   string
+	base        Config                     // the config the runtime changes apply over
+	overrides   map[string]json.RawMessage // config fields changed at runtime
+	biasFactors *BiasFactors               // learned, kept apart from the runtime changes
 }
 
-func New(sc *solcast.Client, gec *givenergy.Client, opts ...Option) *Forecaster {
-	// Eco 7 times in the UK do not shift with BST
-	acChargeStart := time.Date(1, 1, 1, 0, 35, 0, 0, time.UTC)
-	acChargeEnd := time.Date(1, 1, 1, 7, 25, 0, 0, time.UTC)
-
-	projector := &Forecaster{
-		sc:  sc,
-		gec: gec,
-		config: &Config{
-			StorageCapacityKwh:      7.38,          // todo consume from ge cloud (inverter/getInverterInfo)
-			InverterEfficiency:      0.965,         // todo consume from ge cloud
-			ACChargeStart:           acChargeStart, // todo consume from ge cloud (BatteryData/All)
-			ACChargeEnd:             acChargeEnd,   // todo consume from ge cloud (BatteryData/All)
-			BatteryLowerReserve:     4.0,           // todo consume from ge cloud (BatteryData/All)
-			MaxChargeKw:             3.0,           // todo consume from ge cloud
-			MaxDischargeKw:          3.0,           // todo consume from ge cloud
-			BatteryUpperReserve:     100.0,
-			AutomaticTargetsEnabled: true,
-		},
-	}
-
-	ackws := os.Getenv("AVG_CONS_KWH") // todo do this properly using the opts
-	if ackws != "" {
-		ackw, err := strconv.ParseFloat(ackws, 10)
-		if err != nil {
-			println(fmt.Errorf("err parsing AVG_CONS_KWH: %w", err).Error())
-		} else {
-			projector.config.AvgConsumptionKw = ackw
-		}
-	}
-
-	sckwhs := os.Getenv("STORAGE_CAPACITY_KWH") // todo do this properly using the opts
-	if sckwhs != "" {
-		sckwh, err := strconv.ParseFloat(sckwhs, 10)
-		if err != nil {
-			println(fmt.Errorf("err parsing STORAGE_CAPACITY_KWH: %w", err).Error())
-		} else {
-			projector.config.StorageCapacityKwh = sckwh
-		}
-	}
-
-	mckws := os.Getenv("MAX_CHARGE_KW") // todo do this properly using the opts
-	if mckws != "" {
-		mckw, err := strconv.ParseFloat(mckws, 10)
-		if err != nil {
-			println(fmt.Errorf("err parsing MAX_CHARGE_KW: %w", err).Error())
-		} else {
-			projector.config.MaxChargeKw = mckw
-		}
+// DefaultConfig is the config used when none is given with WithConfig. The battery and inverter parameters are
+// usually replaced by the values discovered from the givenergy cloud, the efficiency isn't published.
+func DefaultConfig() Config {
+	return Config{
+		StorageCapacityKwh:      7.38,
+		InverterEfficiency:      0.965,
+		ChargeWindows:           []ChargeWindow{defaultChargeWindow()},
+		BatteryLowerReserve:     4.0,
+		MaxChargeKw:             3.0,
+		MaxDischargeKw:          3.0,
+		BatteryUpperReserve:     100.0,
+		AutomaticTargetsEnabled: true,
+		RiskAppetite:            0.5,
 	}
+}
 
-	mdhws := os.Getenv("MAX_DISCHARGE_KW") // todo do this properly using the opts
-	if mdhws != "" {
-		mdhw, err := strconv.ParseFloat(mdhws, 10)
-		if err != nil {
-			println(fmt.Errorf("err parsing MAX_DISCHARGE_KW: %w", err).Error())
-		} else {
-			projector.config.MaxDischargeKw = mdhw
-		}
+func New(sc SolarForecastProvider, gec *givenergy.Client, opts ...Option) *Forecaster {
+	config := DefaultConfig()
+	projector := &Forecaster{
+		sc:        sc,
+		gec:       gec,
+		overrides: make(map[string]json.RawMessage),
+		config:    &config,
 	}
 
-	burs := os.Getenv("BATTERY_UPPER_RESERVE") // todo do this properly using the opts
-	if burs != "" {
-		bur, err := strconv.ParseFloat(burs, 10)
-		if err != nil {
-			println(fmt.Errorf("err parsing BATTERY_UPPER_RESERVE: %w", err).Error())
-		} else {
-			projector.config.BatteryUpperReserve = bur
-		}
+	for _, opt := range opts {
+		opt(projector)
 	}
+	projector.base = projector.config.clone()
 
-	blrs := os.Getenv("BATTERY_LOWER_RESERVE") // todo do this properly using the opts
-	if blrs != "" {
-		blr, err := strconv.ParseFloat(blrs, 10)
+	if projector.configDir != "" {
+		err := projector.loadOverrides()
 		if err != nil {
-			println(fmt.Errorf("err parsing BATTERY_LOWER_RESERVE: %w", err).Error())
-		} else {
-			projector.config.BatteryLowerReserve = blr
+			println(fmt.Errorf("err restoring config: %w", err).Error())
 		}
 	}
 
-	for _, opt := range opts {
-		opt(projector)
-	}
-
 	return projector
 }
 
 type ForecastDay struct {
 	Date                    time.Time
+	Scenario                string
 	ProductionKwh           float64
 	ConsumptionKwh          float64
 	ChargeKwh               float64
 	DischargeKwh            float64
+	GridImportKwh           float64
+	GridExportKwh           float64
 	RecommendedChargeTarget float64
+	ReplannedAt             time.Time // set when the day was re-simulated from the live state of charge
+	StartSOC                float64
+	EndSOC                  float64
+	ChargeSlots             []*ChargeSlot
+	ImportCostPence         float64
+	ExportSlots             []*ExportSlot
+	ExportRevenuePence      float64
+	ForecastRevision        time.Time // update time of the solar forecast the day was simulated from
 	Forecasts               []*Forecast
+	Scenarios               []*ForecastDay
+}
+
+// estimate selects the solar production in kW to simulate with from a solcast forecast period
+type estimate func(forecast solcast.Forecast) float64
+
+// percentile returns an estimate interpolating between P10 at 0, P50 at 0.5 and P90 at 1
+func percentile(p float64) estimate {
+	p = math.Max(0, math.Min(1, p))
+	return func(forecast solcast.Forecast) float64 {
+		if p <= 0.5 {
+			return forecast.PvEstimate10 + (forecast.PvEstimate-forecast.PvEstimate10)*(p/0.5)
+		}
+		return forecast.PvEstimate + (forecast.PvEstimate90-forecast.PvEstimate)*((p-0.5)/0.5)
+	}
+}
+
+func scenarioName(p float64) string {
+	return fmt.Sprintf("p%.0f", 10+math.Max(0, math.Min(1, p))*80)
 }
 
 type Simulation struct {
@@ -145,66 +160,153 @@ type Simulation struct {
 	ConsumptionKwh                     float64
 	ChargeKwh                          float64
 	DischargeKwh                       float64
+	GridImportKwh                      float64
+	GridExportKwh                      float64
 	Forecasts                          []*Forecast
 	DayStorageMaxKwh                   float64
 	ConsumptionBeforeSelfSufficientKwh float64
+	ImportCostPence                    float64
+	ChargeSlots                        []*ChargeSlot
+	ExportSlots                        []*ExportSlot
+	ExportRevenuePence                 float64
+	ForecastRevision                   time.Time
 }
 
 type Forecast struct {
-	PeriodEnd      time.Time
-	ProductionKwh  float64
-	ConsumptionKwh float64
-	ChargeKwh      float64
-	DischargeKwh   float64
-	ProductionW    float64
-	ConsumptionW   float64
-	ChargeW        float64
-	DischargeW     float64
-	SOC            float64
+	PeriodEnd       time.Time
+	ProductionKwh   float64
+	ConsumptionKwh  float64
+	ChargeKwh       float64
+	DischargeKwh    float64
+	GridImportKwh   float64
+	GridExportKwh   float64
+	ProductionW     float64
+	ConsumptionW    float64
+	ChargeW         float64
+	DischargeW      float64
+	GridImportW     float64
+	GridExportW     float64
+	SOC             float64
+	SiteProductionW map[string]float64 `json:",omitempty"` // production of each solcast site when there's more than one
+	shortfallKwh    float64            // load left unmet because the battery hit its reserve
+	gridChargeKwh   float64            // energy stored from the grid during the period
+	forcedExportKwh float64            // energy exported from the battery during the period
+	spilledKwh      float64            // solar surplus the battery couldn't take, exported or curtailed
+}
+
+type ChargeSlot struct {
+	Start     time.Time
+	End       time.Time
+	RatePence float64
+	Kwh       float64 // energy to store from the grid
+	TargetSOC float64 // SOC to stop grid charging at, 0 charges to the recommended target
 }
 
+// GetConfig returns a copy of the config, changes only take effect through SetConfig
 func (f *Forecaster) GetConfig() *Config {
-	return f.config
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	c := f.config.clone()
+	return &c
+}
+
+// clone copies c without sharing its windows
+func (c Config) clone() Config {
+	if c.ChargeWindows != nil {
+		c.ChargeWindows = append(make([]ChargeWindow, 0, len(c.ChargeWindows)), c.ChargeWindows...)
+	}
+	if c.ExportWindows != nil {
+		c.ExportWindows = append(make([]ExportWindow, 0, len(c.ExportWindows)), c.ExportWindows...)
+	}
+	return c
 }
 
-func (f *Forecaster) SetConfig(c Config) {
+// SetConfig replaces the config, persisting the fields that changed when a config dir is set. An invalid config is
+// rejected with a ValidationError.
+func (f *Forecaster) SetConfig(c Config) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
 	f.m.Lock()
 	defer f.m.Unlock()
 
+	// the config only changes once it's been saved so a failed save doesn't leave a change that's lost on restart
+	err = f.saveOverrides(f.config, &c)
+	if err != nil {
+		return err
+	}
+	if c.BiasFactors != f.config.BiasFactors {
+		err = f.saveBiasFactors(c.BiasFactors)
+		if err != nil {
+			return err
+		}
+	}
 	f.config = &c
-	return
+
+	return nil
 }
 
-func (f *Forecaster) ForecastNow(t time.Time) (*Forecast, error) {
-	chargingPeriodStart := time.Date(t.Year(), t.Month(), t.Day(), f.config.ACChargeStart.Hour(), f.config.ACChargeStart.Minute(), 0, 0, time.UTC)
-	chargingPeriodEnd := time.Date(t.Year(), t.Month(), t.Day(), f.config.ACChargeEnd.Hour(), f.config.ACChargeEnd.Minute(), 0, 0, time.UTC)
+// ConsumptionW returns the consumption assumed for the half hour period ending at t in W
+func (f *Forecaster) ConsumptionW(t time.Time) (float64, error) {
+	if f.config.AvgConsumptionKw != 0 {
+		return f.config.AvgConsumptionKw * 1000, nil
+	}
+
+	consumptionAverages, err := f.gec.GetConsumptionAverages()
+	if err != nil {
+		return 0, err
+	}
+
+	return consumptionAverages.At(t), nil
+}
 
-	// if it's after midnight,
-	// and it's before the charging period
+// forecastDate returns the date of the forecast covering t
+func (f *Forecaster) forecastDate(t time.Time) time.Time {
+	chargingPeriodStart, _ := f.primaryWindow().On(t)
+
+	// if it's before the charging period that ends today
 	// we actually want yesterday's forecast
-	// this again assumes the charging period occurs during the early hours of the morning
+	// planned days run from midnight so don't need adjusting
 	ft := t
-	if t.Hour() >= 0 && t.Before(chargingPeriodStart) {
+	if f.tc == nil && t.Before(chargingPeriodStart) {
 		ft = ft.AddDate(0, 0, -1)
 	}
 
-	fc, err := f.Forecast(ft)
-	if err != nil {
-		return nil, err
+	return time.Date(ft.Local().Year(), ft.Local().Month(), ft.Local().Day(), 0, 0, 0, 0, time.Local)
+}
+
+func (f *Forecaster) ForecastNow(t time.Time) (*Forecast, error) {
+	chargingPeriodStart, chargingPeriodEnd := f.primaryWindow().On(t)
+	ft := f.forecastDate(t)
+
+	fc := f.LastReplan(ft)
+	if fc == nil || len(fc.Forecasts) == 0 || t.Before(fc.Forecasts[0].PeriodEnd.Add(-30*time.Minute)) {
+		var err error
+		fc, err = f.Forecast(ft)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// if we're within the charging period
-	if t.After(chargingPeriodStart) && t.Before(chargingPeriodEnd) {
+	if f.tc == nil && t.After(chargingPeriodStart) && t.Before(chargingPeriodEnd) {
 		return &Forecast{
 			PeriodEnd:      chargingPeriodEnd,
 			ProductionKwh:  0,
 			ConsumptionKwh: 0,
 			ChargeKwh:      0,
 			DischargeKwh:   0,
+			GridImportKwh:  0,
+			GridExportKwh:  0,
 			ProductionW:    0,
 			ConsumptionW:   0,
 			ChargeW:        0,
 			DischargeW:     0,
+			GridImportW:    0,
+			GridExportW:    0,
 			SOC:            fc.RecommendedChargeTarget,
 		}, nil
 	}
@@ -220,10 +322,14 @@ func (f *Forecaster) ForecastNow(t time.Time) (*Forecast, error) {
 			ConsumptionKwh: forecast.ConsumptionKwh,
 			ChargeKwh:      forecast.ChargeKwh,
 			DischargeKwh:   forecast.DischargeKwh,
+			GridImportKwh:  forecast.GridImportKwh,
+			GridExportKwh:  forecast.GridExportKwh,
 			ProductionW:    forecast.ProductionW,
 			ConsumptionW:   forecast.ConsumptionW,
 			ChargeW:        forecast.ChargeW,
 			DischargeW:     forecast.DischargeW,
+			GridImportW:    forecast.GridImportW,
+			GridExportW:    forecast.GridExportW,
 			SOC:            forecast.SOC,
 		}, nil
 	}
@@ -232,8 +338,30 @@ func (f *Forecaster) ForecastNow(t time.Time) (*Forecast, error) {
 }
 
 func (f *Forecaster) Forecast(t time.Time) (*ForecastDay, error) {
+	fd, err := f.forecast(t, f.config.RiskAppetite)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range []float64{0, 0.5, 1} {
+		scenario, err := f.forecast(t, p)
+		if err != nil {
+			return nil, err
+		}
+		fd.Scenarios = append(fd.Scenarios, scenario)
+	}
+
+	return fd, nil
+}
+
+func (f *Forecaster) forecast(t time.Time, p float64) (*ForecastDay, error) {
+	if f.tc != nil {
+		return f.plan(t, p, (f.config.BatteryLowerReserve/100)*f.config.StorageCapacityKwh)
+	}
+
+	pv := percentile(p)
 	storageReserveKwh := (f.config.BatteryLowerReserve / 100) * f.config.StorageCapacityKwh
-	simulation, err := f.simulate(t, storageReserveKwh)
+	simulation, err := f.simulate(t, storageReserveKwh, pv)
 	if err != nil {
 		return nil, err
 	}
@@ -244,7 +372,7 @@ func (f *Forecaster) Forecast(t time.Time) (*ForecastDay, error) {
 	if recommendedChargeKwh < simulation.ConsumptionBeforeSelfSufficientKwh {
 		recommendedChargeKwh = recommendedChargeKwh + (simulation.ConsumptionBeforeSelfSufficientKwh - recommendedChargeKwh)
 	}
-	simulation, err = f.simulate(t, recommendedChargeKwh)
+	simulation, err = f.simulate(t, recommendedChargeKwh, pv)
 	if err != nil {
 		return nil, err
 	}
@@ -264,74 +392,158 @@ func (f *Forecaster) Forecast(t time.Time) (*ForecastDay, error) {
 		recommendedChargeKwh = f.config.StorageCapacityKwh
 	}
 
-	simulation, err = f.simulate(t, recommendedChargeKwh)
+	return f.day(t, p, recommendedChargeKwh, recommendedChargeKwh)
+}
+
+// day simulates t starting from startKwh, reporting targetKwh as the recommended charge
+func (f *Forecaster) day(t time.Time, p float64, targetKwh, startKwh float64) (*ForecastDay, error) {
+	simulation, err := f.runWithExports(f.dayRun(t, startKwh, percentile(p)))
 	if err != nil {
 		return nil, err
 	}
 
-	recommendedChargeTarget := (recommendedChargeKwh / f.config.StorageCapacityKwh) * 100
+	return f.forecastDay(t, p, simulation, targetKwh, startKwh), nil
+}
+
+func (f *Forecaster) forecastDay(t time.Time, p float64, simulation *Simulation, targetKwh, startKwh float64) *ForecastDay {
+	startSOC := (startKwh / f.config.StorageCapacityKwh) * 100
+	endSOC := startSOC
+	if len(simulation.Forecasts) > 0 {
+		endSOC = simulation.Forecasts[len(simulation.Forecasts)-1].SOC
+	}
+
 	return &ForecastDay{
 		Date:                    t,
+		Scenario:                scenarioName(p),
 		ProductionKwh:           simulation.ProductionKwh,
 		ConsumptionKwh:          simulation.ConsumptionKwh,
 		ChargeKwh:               simulation.ChargeKwh,
 		DischargeKwh:            simulation.DischargeKwh,
-		RecommendedChargeTarget: recommendedChargeTarget,
+		GridImportKwh:           simulation.GridImportKwh,
+		GridExportKwh:           simulation.GridExportKwh,
+		RecommendedChargeTarget: (targetKwh / f.config.StorageCapacityKwh) * 100,
+		StartSOC:                startSOC,
+		EndSOC:                  endSOC,
+		ImportCostPence:         simulation.ImportCostPence,
+		ExportSlots:             simulation.ExportSlots,
+		ExportRevenuePence:      simulation.ExportRevenuePence,
+		ForecastRevision:        simulation.ForecastRevision,
+		ChargeSlots:             append([]*ChargeSlot{f.primarySlot(t)}, simulation.ChargeSlots...),
 		Forecasts:               simulation.Forecasts,
-	}, nil
+	}
+}
+
+// primarySlot is the occurrence of the primary charge window charging to the recommended target on t
+func (f *Forecaster) primarySlot(t time.Time) *ChargeSlot {
+	start, end := f.primaryWindow().On(t)
+	return &ChargeSlot{
+		Start: start.Local(),
+		End:   end.Local(),
+	}
+}
+
+// run describes a single pass of the simulation over the periods ending after from up to and including to
+type run struct {
+	from     time.Time
+	to       time.Time
+	startKwh float64
+	pv       estimate
+	slots    map[time.Time]*ChargeSlot // grid charge slots keyed by period end
+	windows  []*ChargeSlot             // charge window occurrences to report the grid charge for
+	rates    *tariff.RateData
+	exports  map[time.Time]float64 // battery energy to force export keyed by period end
 }
 
-func (f *Forecaster) simulate(t time.Time, storageDayStartKwh float64) (*Simulation, error) {
+// dayRun covers the day from the end of the primary charge window on t until it next starts, secondary windows
+// without a target charge to the day's starting charge
+func (f *Forecaster) dayRun(t time.Time, storageDayStartKwh float64, pv estimate) run {
+	t = time.Date(t.Local().Year(), t.Local().Month(), t.Local().Day(), 0, 0, 0, 0, time.Local)
+	_, chargingPeriodEnd := f.primaryWindow().On(t)
+	nextChargingPeriodStart, _ := f.primaryWindow().On(t.AddDate(0, 0, 1))
+
+	// rounded to whole periods to avoid the partial periods caused by charge window offsets
+	dischargingPeriodStart := chargingPeriodEnd.Truncate(30 * time.Minute)
+	if !dischargingPeriodStart.Equal(chargingPeriodEnd) {
+		dischargingPeriodStart = dischargingPeriodStart.Add(30 * time.Minute)
+	}
+	dischargingPeriodEnd := nextChargingPeriodStart.Truncate(30 * time.Minute)
+
+	slots, windows := f.windowSlots(dischargingPeriodStart, dischargingPeriodEnd, storageDayStartKwh)
+	return run{
+		from:     dischargingPeriodStart,
+		to:       dischargingPeriodEnd,
+		startKwh: storageDayStartKwh,
+		pv:       pv,
+		slots:    slots,
+		windows:  windows,
+	}
+}
+
+func (f *Forecaster) simulate(t time.Time, storageDayStartKwh float64, pv estimate) (*Simulation, error) {
+	return f.run(f.dayRun(t, storageDayStartKwh, pv))
+}
+
+func (f *Forecaster) run(r run) (*Simulation, error) {
 	forecast, err := f.sc.GetForecast()
 	if err != nil {
 		return nil, err
 	}
 
-	var consumptionAverages map[time.Time]float64
+	var consumptionAverages *givenergy.ConsumptionAverages
 	if f.config.AvgConsumptionKw == 0 {
-		//consumptionAverages, err = f.gec.GetConsumptionAverages()
-		//if err != nil {
-		//	return nil, err
-		//}
+		consumptionAverages, err = f.gec.GetConsumptionAverages()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	t = time.Date(t.Local().Year(), t.Local().Month(), t.Local().Day(), 0, 0, 0, 0, time.Local)
-	dischargingPeriodStart := time.Date(t.Year(), t.Month(), t.Day(), f.config.ACChargeEnd.Hour(), 30, 0, 0, time.UTC) // minute hardcoded to avoid initial 5m period caused by charge window offsets
-	// This will only work if the charging period starts after midnight as we're assuming this and setting the date to tomorrow
-	dischargingPeriodEnd := time.Date(t.Year(), t.Month(), t.Day()+1, f.config.ACChargeStart.Hour(), 30, 0, 0, time.UTC) // minute hardcoded to avoid initial 5m period caused by charge window offsets
 	storageReserveKwh := (f.config.BatteryLowerReserve / 100) * f.config.StorageCapacityKwh
 
-	var dayProductionKwh, dayConsumptionKwh, dayDischargeKwh, dayChargeKwh, dayStorageKwh, dayStorageMaxKwh, consumptionBeforeSelfSufficientKwh float64
+	var dayProductionKwh, dayConsumptionKwh, dayDischargeKwh, dayChargeKwh, dayGridImportKwh, dayGridExportKwh, dayStorageKwh, dayStorageMaxKwh, consumptionBeforeSelfSufficientKwh, importCostPence float64
 	var selfSufficient bool
-	dayStorageKwh = storageDayStartKwh
+	dayStorageKwh = r.startKwh
+
+	sites := make(map[string]map[time.Time]solcast.Forecast)
+	for site, siteForecasts := range forecast.Sites {
+		sites[site] = make(map[time.Time]solcast.Forecast)
+		for _, sf := range siteForecasts {
+			sites[site][sf.PeriodEnd.UTC()] = sf
+		}
+	}
+
 	var forecasts []*Forecast
 	for _, forecast := range forecast.Forecasts {
-		if forecast.PeriodEnd.After(dischargingPeriodEnd) {
+		if forecast.PeriodEnd.After(r.to) {
 			continue
 		}
 
-		if forecast.PeriodEnd.Before(dischargingPeriodStart) || forecast.PeriodEnd.Equal(dischargingPeriodStart) {
+		if forecast.PeriodEnd.Before(r.from) || forecast.PeriodEnd.Equal(r.from) {
 			continue
 		}
 
 		consumptionKwh := 0.0
 		if f.config.AvgConsumptionKw != 0 {
 			consumptionKwh = f.config.AvgConsumptionKw * 0.5
-		} else if forecast.PeriodEnd.Minute() == 0 { // todo replace time.roundUpTo()
-			consumptionKwh = (consumptionAverages[time.Date(1, 1, 1, forecast.PeriodEnd.Hour()-1, 30, 0, 0, time.Local)] / 1000) * 0.5
 		} else {
-			consumptionKwh = (consumptionAverages[time.Date(1, 1, 1, forecast.PeriodEnd.Hour(), 0, 0, 0, time.Local)] / 1000) * 0.5
+			consumptionKwh = (consumptionAverages.At(forecast.PeriodEnd) / 1000) * 0.5
+		}
+		if cw, ok := f.consumption[forecast.PeriodEnd.UTC()]; ok {
+			consumptionKwh = (cw / 1000) * 0.5
 		}
 
 		dayConsumptionKwh = dayConsumptionKwh + consumptionKwh
-		productionKwh := forecast.PvEstimate * 0.5
+		productionKwh := r.pv(f.correct(forecast)) * 0.5
 		dayProductionKwh = dayProductionKwh + productionKwh
 
+		slot := r.slots[forecast.PeriodEnd.UTC()]
+
 		netKwh := productionKwh - consumptionKwh
-		var chargeKwh, dischargeKwh float64
+		var chargeKwh, dischargeKwh, gridChargeKwh, importKwh, exportKwh, forcedExportKwh, shortfallKwh, spilledKwh float64
 		if netKwh < 0 {
-			dischargeKwh = math.Min(math.Abs(netKwh)*((1-f.config.InverterEfficiency)+1), f.config.MaxDischargeKw*0.5)
-			dayDischargeKwh = dayDischargeKwh + dischargeKwh
+			if slot == nil {
+				dischargeKwh = math.Min(math.Abs(netKwh)*((1-f.config.InverterEfficiency)+1), f.config.MaxDischargeKw*0.5)
+				dayDischargeKwh = dayDischargeKwh + dischargeKwh
+			}
 
 			if !selfSufficient {
 				consumptionBeforeSelfSufficientKwh = consumptionBeforeSelfSufficientKwh + dischargeKwh
@@ -343,16 +555,81 @@ func (f *Forecaster) simulate(t time.Time, storageDayStartKwh float64) (*Simulat
 			selfSufficient = true
 		}
 
+		prevStorageKwh := dayStorageKwh
 		dayStorageKwh = dayStorageKwh + (chargeKwh - dischargeKwh)
-		if dayStorageKwh < storageReserveKwh { // Handle battery empty
-			dayStorageKwh = storageReserveKwh
+		if dayStorageKwh < storageReserveKwh { // Handle battery empty, discharging only what's left above the reserve
 			dayDischargeKwh = dayDischargeKwh - dischargeKwh
-			dischargeKwh = 0
+			dischargeKwh = math.Max(0, prevStorageKwh-storageReserveKwh)
+			dayDischargeKwh = dayDischargeKwh + dischargeKwh
+			dayStorageKwh = prevStorageKwh - dischargeKwh
+			if netKwh < 0 && slot == nil {
+				shortfallKwh = math.Abs(netKwh) - dischargeKwh/((1-f.config.InverterEfficiency)+1)
+			}
 		}
-		if dayStorageKwh > f.config.StorageCapacityKwh { // Handle battery full
-			dayStorageKwh = f.config.StorageCapacityKwh
+		if dayStorageKwh > f.config.StorageCapacityKwh { // Handle battery full, charging only what's left to fill it
 			dayChargeKwh = dayChargeKwh - chargeKwh
-			chargeKwh = 0
+			chargeKwh = math.Max(0, f.config.StorageCapacityKwh-prevStorageKwh)
+			dayChargeKwh = dayChargeKwh + chargeKwh
+			dayStorageKwh = prevStorageKwh + chargeKwh
+		}
+
+		if netKwh < 0 {
+			importKwh = math.Abs(netKwh) - dischargeKwh/((1-f.config.InverterEfficiency)+1)
+		}
+
+		// any surplus the battery can't take is exported, up to the export limit
+		if netKwh > 0 {
+			exportKwh = netKwh - chargeKwh/f.config.InverterEfficiency
+			spilledKwh = exportKwh
+			if f.config.ExportLimitKw != 0 {
+				exportKwh = math.Min(exportKwh, f.config.ExportLimitKw*0.5)
+			}
+		}
+
+		if exportDischargeKwh := r.exports[forecast.PeriodEnd.UTC()]; exportDischargeKwh > 0 && slot == nil { // Handle forced export, limited by the discharge rate, export limit and reserve
+			exportDischargeKwh = math.Min(exportDischargeKwh, f.config.MaxDischargeKw*0.5-dischargeKwh)
+			if f.config.ExportLimitKw != 0 {
+				exportDischargeKwh = math.Min(exportDischargeKwh, (f.config.ExportLimitKw*0.5-exportKwh)*((1-f.config.InverterEfficiency)+1))
+			}
+			exportDischargeKwh = math.Max(0, math.Min(exportDischargeKwh, dayStorageKwh-storageReserveKwh))
+			dayStorageKwh = dayStorageKwh - exportDischargeKwh
+			dischargeKwh = dischargeKwh + exportDischargeKwh
+			dayDischargeKwh = dayDischargeKwh + exportDischargeKwh
+			forcedExportKwh = exportDischargeKwh / ((1 - f.config.InverterEfficiency) + 1)
+			exportKwh = exportKwh + forcedExportKwh
+		}
+		dayGridExportKwh = dayGridExportKwh + exportKwh
+
+		if slot != nil { // Handle grid charging, the battery holds while charging so load is met from the grid
+			limitKwh := (f.config.BatteryUpperReserve / 100) * f.config.StorageCapacityKwh
+			if slot.TargetSOC != 0 {
+				limitKwh = math.Min(limitKwh, (slot.TargetSOC/100)*f.config.StorageCapacityKwh)
+			}
+			gridChargeKwh = math.Min(slot.Kwh, f.config.MaxChargeKw*0.5-chargeKwh)
+			gridChargeKwh = math.Max(0, math.Min(gridChargeKwh, limitKwh-dayStorageKwh))
+			dayStorageKwh = dayStorageKwh + gridChargeKwh
+			chargeKwh = chargeKwh + gridChargeKwh
+			dayChargeKwh = dayChargeKwh + gridChargeKwh
+		}
+
+		importKwh = importKwh + gridChargeKwh/f.config.InverterEfficiency
+		dayGridImportKwh = dayGridImportKwh + importKwh
+
+		if r.rates != nil {
+			rate, ok := r.rates.At(forecast.PeriodEnd.Add(-30 * time.Minute))
+			if ok {
+				importCostPence = importCostPence + importKwh*rate.ValueIncVat
+			}
+		}
+
+		var siteProductionW map[string]float64
+		if len(sites) > 0 {
+			siteProductionW = make(map[string]float64)
+			for site, sf := range sites {
+				if sf, ok := sf[forecast.PeriodEnd.UTC()]; ok {
+					siteProductionW[site] = r.pv(f.correct(sf)) * 1000
+				}
+			}
 		}
 
 		storageSOC := (dayStorageKwh / f.config.StorageCapacityKwh) * 100
@@ -361,26 +638,51 @@ func (f *Forecaster) simulate(t time.Time, storageDayStartKwh float64) (*Simulat
 		}
 
 		forecasts = append(forecasts, &Forecast{
-			PeriodEnd:      forecast.PeriodEnd.Local(),
-			ProductionKwh:  dayProductionKwh,
-			ConsumptionKwh: dayConsumptionKwh,
-			ChargeKwh:      dayChargeKwh,
-			DischargeKwh:   dayDischargeKwh,
-			ProductionW:    productionKwh * 2 * 1000,
-			ConsumptionW:   consumptionKwh * 2 * 1000,
-			ChargeW:        chargeKwh * 2 * 1000,
-			DischargeW:     dischargeKwh * 2 * 1000,
-			SOC:            storageSOC,
+			PeriodEnd:       forecast.PeriodEnd.Local(),
+			ProductionKwh:   dayProductionKwh,
+			ConsumptionKwh:  dayConsumptionKwh,
+			ChargeKwh:       dayChargeKwh,
+			DischargeKwh:    dayDischargeKwh,
+			GridImportKwh:   dayGridImportKwh,
+			GridExportKwh:   dayGridExportKwh,
+			ProductionW:     productionKwh * 2 * 1000,
+			ConsumptionW:    consumptionKwh * 2 * 1000,
+			ChargeW:         chargeKwh * 2 * 1000,
+			DischargeW:      dischargeKwh * 2 * 1000,
+			GridImportW:     importKwh * 2 * 1000,
+			GridExportW:     exportKwh * 2 * 1000,
+			SOC:             storageSOC,
+			SiteProductionW: siteProductionW,
+			shortfallKwh:    shortfallKwh,
+			gridChargeKwh:   gridChargeKwh,
+			forcedExportKwh: forcedExportKwh,
+			spilledKwh:      spilledKwh,
 		})
 	}
 
+	var chargeSlots []*ChargeSlot
+	for _, window := range r.windows {
+		cs := *window
+		for _, fc := range forecasts {
+			if fc.PeriodEnd.After(cs.Start) && fc.PeriodEnd.Add(-30*time.Minute).Before(cs.End) {
+				cs.Kwh = cs.Kwh + fc.gridChargeKwh
+			}
+		}
+		chargeSlots = append(chargeSlots, &cs)
+	}
+
 	return &Simulation{
 		ProductionKwh:                      dayProductionKwh,
 		ConsumptionKwh:                     dayConsumptionKwh,
 		ChargeKwh:                          dayChargeKwh,
 		DischargeKwh:                       dayDischargeKwh,
+		GridImportKwh:                      dayGridImportKwh,
+		GridExportKwh:                      dayGridExportKwh,
 		Forecasts:                          forecasts,
 		DayStorageMaxKwh:                   dayStorageMaxKwh,
 		ConsumptionBeforeSelfSufficientKwh: consumptionBeforeSelfSufficientKwh,
+		ImportCostPence:                    importCostPence,
+		ChargeSlots:                        chargeSlots,
+		ForecastRevision:                   forecast.UpdatedAt,
 	}, nil
 }
@@ -0,0 +1,373 @@
+package forecaster
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/tariff"
+)
+
+func TestMain(m *testing.M) {
+	// the expected trajectories are worked out in UTC, pin local time so BST doesn't shift the windows
+	time.Local = time.UTC
+	os.Exit(m.Run())
+}
+
+var day = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func clock(hour, minute int) time.Time {
+	return time.Date(1, 1, 1, hour, minute, 0, 0, time.UTC)
+}
+
+// testConfig is a lossless 10kWh battery with a 1kWh reserve, 1kWh a half hour charge and discharge rates and a
+// constant 0.25kWh a half hour load, charged overnight from 00:00 to 07:00
+func testConfig() Config {
+	c := DefaultConfig()
+	c.StorageCapacityKwh = 10
+	c.InverterEfficiency = 1
+	c.ChargeWindows = []ChargeWindow{{Start: clock(0, 0), End: clock(7, 0)}}
+	c.BatteryLowerReserve = 10
+	c.MaxChargeKw = 2
+	c.MaxDischargeKw = 2
+	c.AvgConsumptionKw = 0.5
+	return c
+}
+
+type estimates struct {
+	p10, p50, p90 float64
+}
+
+// solar forecasts days from start with the given kW estimates for the periods ending from 10:30 to 14:00, none
+// otherwise. The day after is forecast without solar so the last day's run to the next charge window is covered.
+func solar(start time.Time, days ...estimates) *StaticForecast {
+	fcd := &solcast.ForecastData{UpdatedAt: start.Add(-time.Hour)}
+	for i, e := range append(days, estimates{}) {
+		d := start.AddDate(0, 0, i)
+		for pe := d.Add(30 * time.Minute); !pe.After(d.AddDate(0, 0, 1)); pe = pe.Add(30 * time.Minute) {
+			forecast := solcast.Forecast{PeriodEnd: pe, Period: "PT30M"}
+			if pe.After(d.Add(10*time.Hour)) && !pe.After(d.Add(14*time.Hour)) {
+				forecast.PvEstimate10, forecast.PvEstimate, forecast.PvEstimate90 = e.p10, e.p50, e.p90
+			}
+			fcd.Forecasts = append(fcd.Forecasts, forecast)
+		}
+	}
+	return NewStaticForecast(fcd)
+}
+
+// socAt returns the state of charge at the end of the period ending at t
+func socAt(t *testing.T, fd *ForecastDay, pe time.Time) float64 {
+	t.Helper()
+
+	for _, fc := range fd.Forecasts {
+		if fc.PeriodEnd.Equal(pe) {
+			return fc.SOC
+		}
+	}
+	t.Fatalf("no forecast for the period ending %s", pe.Format(time.RFC3339))
+	return 0
+}
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestForecastScenarios(t *testing.T) {
+	config := testConfig()
+	f := New(solar(day, estimates{p10: 0, p50: 2, p90: 4}), nil, WithConfig(&config))
+
+	fd, err := f.Forecast(day)
+	if err != nil {
+		t.Fatalf("Forecast() error = %v", err)
+	}
+	if len(fd.Scenarios) != 3 {
+		t.Fatalf("got %d scenarios, want 3", len(fd.Scenarios))
+	}
+
+	// the day runs from 07:00, drawing 1.5kWh before the solar from 10:00 to 14:00 and 5kWh after it
+	tests := []struct {
+		name      string
+		fd        *ForecastDay
+		target    float64
+		soc       map[int]float64 // SOC at the end of the period ending at the hour
+		endSOC    float64
+		exportKwh float64
+	}{
+		{
+			// nothing to charge from so the target is full
+			name:   "p10",
+			fd:     fd.Scenarios[0],
+			target: 100,
+			soc:    map[int]float64{10: 85, 14: 65},
+			endSOC: 15,
+		},
+		{
+			// the 6kWh of surplus exactly fills the battery from the target
+			name:   "p50",
+			fd:     fd.Scenarios[1],
+			target: 55,
+			soc:    map[int]float64{10: 40, 14: 100},
+			endSOC: 50,
+		},
+		{
+			// the surplus is charged at the 1kWh a half hour charge rate, the rest is exported
+			name:      "p90",
+			fd:        fd.Scenarios[2],
+			target:    30,
+			soc:       map[int]float64{10: 15, 14: 95},
+			endSOC:    45,
+			exportKwh: 6,
+		},
+		{
+			name:   "risk appetite",
+			fd:     fd,
+			target: 55,
+			soc:    map[int]float64{10: 40, 14: 100},
+			endSOC: 50,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !near(tt.fd.RecommendedChargeTarget, tt.target, 1e-9) {
+				t.Errorf("target = %v, want %v", tt.fd.RecommendedChargeTarget, tt.target)
+			}
+			if !near(tt.fd.StartSOC, tt.target, 1e-9) {
+				t.Errorf("start SOC = %v, want the target %v", tt.fd.StartSOC, tt.target)
+			}
+			for hour, soc := range tt.soc {
+				if got := socAt(t, tt.fd, day.Add(time.Duration(hour)*time.Hour)); !near(got, soc, 1e-9) {
+					t.Errorf("SOC at %02d:00 = %v, want %v", hour, got, soc)
+				}
+			}
+			if !near(tt.fd.EndSOC, tt.endSOC, 1e-9) {
+				t.Errorf("end SOC = %v, want %v", tt.fd.EndSOC, tt.endSOC)
+			}
+			if !near(tt.fd.GridImportKwh, 0, 1e-9) || !near(tt.fd.GridExportKwh, tt.exportKwh, 1e-9) {
+				t.Errorf("import %vkWh, export %vkWh, want 0 and %v", tt.fd.GridImportKwh, tt.fd.GridExportKwh, tt.exportKwh)
+			}
+
+			// the target is charged in the overnight window
+			if len(tt.fd.ChargeSlots) != 1 || !tt.fd.ChargeSlots[0].Start.Equal(day) || !tt.fd.ChargeSlots[0].End.Equal(day.Add(7*time.Hour)) {
+				t.Errorf("charge slots %+v, want the overnight window", tt.fd.ChargeSlots)
+			}
+		})
+	}
+}
+
+func TestForecastExportLimit(t *testing.T) {
+	config := testConfig()
+	config.RiskAppetite = 1
+	config.ExportLimitKw = 1
+	f := New(solar(day, estimates{p90: 4}), nil, WithConfig(&config))
+
+	fd, err := f.Forecast(day)
+	if err != nil {
+		t.Fatalf("Forecast() error = %v", err)
+	}
+
+	// 0.75kWh a half hour is left over after charging but only 0.5kWh can be exported
+	if !near(fd.GridExportKwh, 4, 1e-9) {
+		t.Errorf("export = %vkWh, want 4kWh", fd.GridExportKwh)
+	}
+	if got := socAt(t, fd, day.Add(12*time.Hour)); !near(got, 55, 1e-9) {
+		t.Errorf("SOC at 12:00 = %v, want 55", got)
+	}
+	for _, fc := range fd.Forecasts {
+		if fc.GridExportW > 1000+1e-9 {
+			t.Errorf("period ending %s exports %vW, over the 1kW limit", fc.PeriodEnd.Format("15:04"), fc.GridExportW)
+		}
+	}
+}
+
+func TestForecastRangeCarry(t *testing.T) {
+	config := testConfig()
+	// a one hour overnight window can only add 2kWh
+	config.ChargeWindows = []ChargeWindow{{Start: clock(6, 0), End: clock(7, 0)}}
+	f := New(solar(day, estimates{4, 4, 4}, estimates{}), nil, WithConfig(&config))
+
+	fr, err := f.ForecastRange(day, 2)
+	if err != nil {
+		t.Fatalf("ForecastRange() error = %v", err)
+	}
+	if len(fr.Days) != 2 {
+		t.Fatalf("got %d days, want 2", len(fr.Days))
+	}
+
+	// the sunless second day needs a full battery, which the window can't reach from the first day's 15% end,
+	// so the first day's target is raised by the 6.5kWh shortfall
+	tests := []struct {
+		name      string
+		fd        *ForecastDay
+		target    float64
+		startSOC  float64
+		endSOC    float64
+		importKwh float64
+	}{
+		{name: "sunny", fd: fr.Days[0], target: 95, startSOC: 95, endSOC: 20},
+		// the window takes the battery from 20% to 40%, leaving 34 periods of load on the grid once it's at the reserve
+		{name: "sunless", fd: fr.Days[1], target: 100, startSOC: 40, endSOC: 10, importKwh: 8.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !near(tt.fd.RecommendedChargeTarget, tt.target, 1e-9) {
+				t.Errorf("target = %v, want %v", tt.fd.RecommendedChargeTarget, tt.target)
+			}
+			if !near(tt.fd.StartSOC, tt.startSOC, 1e-9) || !near(tt.fd.EndSOC, tt.endSOC, 1e-9) {
+				t.Errorf("SOC %v to %v, want %v to %v", tt.fd.StartSOC, tt.fd.EndSOC, tt.startSOC, tt.endSOC)
+			}
+			if !near(tt.fd.GridImportKwh, tt.importKwh, 1e-9) {
+				t.Errorf("import = %vkWh, want %vkWh", tt.fd.GridImportKwh, tt.importKwh)
+			}
+		})
+	}
+
+	if !near(fr.GridImportKwh, 8.5, 1e-9) {
+		t.Errorf("range import = %vkWh, want 8.5kWh", fr.GridImportKwh)
+	}
+}
+
+// fixedRates serves the same rates as current and recorded
+type fixedRates struct {
+	rd tariff.RateData
+}
+
+func (r *fixedRates) GetRates() (*tariff.RateData, error) {
+	return &r.rd, nil
+}
+
+func (r *fixedRates) GetRatesBetween(_, _ time.Time) (*tariff.RateData, error) {
+	return &r.rd, nil
+}
+
+// cheapNights is 5p until 04:00 and 30p for the rest of the day
+func cheapNights(d time.Time) *fixedRates {
+	r := &fixedRates{}
+	for from := d; from.Before(d.AddDate(0, 0, 1)); from = from.Add(30 * time.Minute) {
+		pence := 30.0
+		if from.Before(d.Add(4 * time.Hour)) {
+			pence = 5
+		}
+		r.rd.Rates = append(r.rd.Rates, tariff.Rate{ValueIncVat: pence, ValidFrom: from, ValidTo: from.Add(30 * time.Minute)})
+	}
+	return r
+}
+
+func TestForecastTariffPlan(t *testing.T) {
+	config := testConfig()
+	f := New(solar(day, estimates{}), nil, WithConfig(&config), WithTariff(cheapNights(day)))
+
+	fd, err := f.Forecast(day)
+	if err != nil {
+		t.Fatalf("Forecast() error = %v", err)
+	}
+
+	// every 5p half hour charges at the full rate, the 30p half hours aren't worth charging in
+	if len(fd.ChargeSlots) != 8 {
+		t.Fatalf("got %d charge slots, want the 8 half hours to 04:00", len(fd.ChargeSlots))
+	}
+	for i, slot := range fd.ChargeSlots {
+		start := day.Add(time.Duration(i) * 30 * time.Minute)
+		if !slot.Start.Equal(start) || slot.RatePence != 5 || !near(slot.Kwh, 1, 1e-9) {
+			t.Errorf("slot %d = %s-%s at %vp for %vkWh, want %s at 5p for 1kWh", i, slot.Start.Format("15:04"), slot.End.Format("15:04"), slot.RatePence, slot.Kwh, start.Format("15:04"))
+		}
+	}
+
+	if !near(fd.RecommendedChargeTarget, 90, 1e-9) {
+		t.Errorf("target = %v, want 90", fd.RecommendedChargeTarget)
+	}
+	// 8kWh lasts the 32 half hours to 20:00, the load after that is imported at 30p
+	soc := map[int]float64{4: 90, 12: 50, 20: 10}
+	for hour, want := range soc {
+		if got := socAt(t, fd, day.Add(time.Duration(hour)*time.Hour)); !near(got, want, 1e-9) {
+			t.Errorf("SOC at %02d:00 = %v, want %v", hour, got, want)
+		}
+	}
+	if !near(fd.GridImportKwh, 12, 1e-9) || !near(fd.ImportCostPence, 110, 1e-9) {
+		t.Errorf("import %vkWh for %vp, want 12kWh for 110p", fd.GridImportKwh, fd.ImportCostPence)
+	}
+
+	slots := f.InverterSlots(fd)
+	if len(slots) != 1 || !slots[0].End.Equal(day.Add(4*time.Hour)) || slots[0].TargetSOC != 90 {
+		t.Errorf("inverter slots %+v, want 00:00-04:00 to 90%%", slots)
+	}
+}
+
+func TestReplan(t *testing.T) {
+	config := testConfig()
+	f := New(solar(day, estimates{2, 2, 2}), nil, WithConfig(&config))
+
+	// the battery's at 20% at noon where the forecast had it at 70%
+	noon := day.Add(12 * time.Hour)
+	fd, err := f.Replan(noon, 20)
+	if err != nil {
+		t.Fatalf("Replan() error = %v", err)
+	}
+
+	if !fd.ReplannedAt.Equal(noon) || !near(fd.RecommendedChargeTarget, 55, 1e-9) || !near(fd.StartSOC, 20, 1e-9) {
+		t.Errorf("replanned at %s from %v%% keeping the %v%% target, want noon from 20%% keeping 55%%", fd.ReplannedAt, fd.StartSOC, fd.RecommendedChargeTarget)
+	}
+	if len(fd.ChargeSlots) != 1 || !fd.ChargeSlots[0].End.Equal(day.Add(7*time.Hour)) {
+		t.Errorf("charge slots %+v, want the overnight window kept", fd.ChargeSlots)
+	}
+	if first := fd.Forecasts[0].PeriodEnd; !first.Equal(noon.Add(30 * time.Minute)) {
+		t.Errorf("first period ends %s, want 12:30", first.Format("15:04"))
+	}
+
+	// the last 2 hours of solar take it to 50%, which runs out at 22:00 leaving 4 half hours to import
+	soc := map[int]float64{14: 50, 22: 10}
+	for hour, want := range soc {
+		if got := socAt(t, fd, day.Add(time.Duration(hour)*time.Hour)); !near(got, want, 1e-9) {
+			t.Errorf("SOC at %02d:00 = %v, want %v", hour, got, want)
+		}
+	}
+	if !near(fd.EndSOC, 10, 1e-9) || !near(fd.GridImportKwh, 1, 1e-9) {
+		t.Errorf("end SOC %v importing %vkWh, want 10 importing 1kWh", fd.EndSOC, fd.GridImportKwh)
+	}
+
+	if f.LastReplan(day) != fd {
+		t.Error("LastReplan() isn't the replan")
+	}
+	now, err := f.ForecastNow(noon.Add(10 * time.Minute))
+	if err != nil {
+		t.Fatalf("ForecastNow() error = %v", err)
+	}
+	if !near(now.SOC, 27.5, 1e-9) {
+		t.Errorf("SOC now = %v, want the replanned 27.5", now.SOC)
+	}
+}
+
+func TestForecastExportWindows(t *testing.T) {
+	config := testConfig()
+	config.ExportWindows = []ExportWindow{{Start: clock(16, 0), End: clock(19, 0), RatePence: 15}}
+	f := New(solar(day, estimates{2, 2, 2}), nil, WithConfig(&config))
+
+	fd, err := f.Forecast(day)
+	if err != nil {
+		t.Fatalf("Forecast() error = %v", err)
+	}
+
+	// the battery's at 90% at 16:00 and needs 4kWh for the load after it, leaving 4kWh above the reserve to export
+	if !near(fd.RecommendedChargeTarget, 55, 1e-9) {
+		t.Errorf("target = %v, want 55 unchanged by the export", fd.RecommendedChargeTarget)
+	}
+	if len(fd.ExportSlots) != 1 {
+		t.Fatalf("got %d export slots, want 1", len(fd.ExportSlots))
+	}
+	slot := fd.ExportSlots[0]
+	if !slot.Start.Equal(day.Add(16*time.Hour)) || !slot.End.Equal(day.Add(19*time.Hour)) {
+		t.Errorf("export slot %s-%s, want 16:00-19:00", slot.Start.Format("15:04"), slot.End.Format("15:04"))
+	}
+	if !near(slot.Kwh, 4, 0.01) || !near(slot.TargetSOC, 35, 0.1) {
+		t.Errorf("export slot exports %vkWh down to %v%%, want 4kWh down to 35%%", slot.Kwh, slot.TargetSOC)
+	}
+	if !near(fd.ExportRevenuePence, 60, 0.15) || !near(fd.GridExportKwh, 4, 0.01) {
+		t.Errorf("export %vkWh for %vp, want 4kWh for 60p", fd.GridExportKwh, fd.ExportRevenuePence)
+	}
+
+	// exporting never leaves the battery short later in the day
+	if !near(fd.EndSOC, 10, 0.1) || !near(fd.GridImportKwh, 0, 0.001) {
+		t.Errorf("end SOC %v importing %vkWh, want the 10%% reserve without importing", fd.EndSOC, fd.GridImportKwh)
+	}
+}
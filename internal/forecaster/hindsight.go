@@ -0,0 +1,72 @@
+package forecaster
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+// measured returns a forecaster sharing f's config that simulates with the consumption measured in actuals
+func (f *Forecaster) measured(actuals map[time.Time]givenergy.Actual) *Forecaster {
+	consumption := make(map[time.Time]float64)
+	for pe, actual := range actuals {
+		consumption[pe.UTC()] = actual.ConsumptionW
+	}
+
+	return &Forecaster{
+		sc:          f.sc,
+		gec:         f.gec,
+		tc:          f.tc,
+		config:      f.config,
+		consumption: consumption,
+	}
+}
+
+// measuredSolar serves measured solar production as a forecast with no spread between the estimates
+func measuredSolar(actuals map[time.Time]givenergy.Actual) *StaticForecast {
+	fcd := &solcast.ForecastData{}
+	for pe, actual := range actuals {
+		kw := actual.SolarW / 1000
+		fcd.Forecasts = append(fcd.Forecasts, solcast.Forecast{
+			PvEstimate:   kw,
+			PvEstimate10: kw,
+			PvEstimate90: kw,
+			PeriodEnd:    pe.UTC(),
+			Period:       "PT30M",
+		})
+	}
+	sort.Slice(fcd.Forecasts, func(i, j int) bool {
+		return fcd.Forecasts[i].PeriodEnd.Before(fcd.Forecasts[j].PeriodEnd)
+	})
+
+	return NewStaticForecast(fcd)
+}
+
+// Hindsight recommends the charge target for d with perfect knowledge of the solar production and consumption
+// measured in actuals, which should cover the half hour periods of the whole simulated day
+func (f *Forecaster) Hindsight(d time.Time, actuals map[time.Time]givenergy.Actual) (*ForecastDay, error) {
+	if len(actuals) == 0 {
+		return nil, errors.New("no actuals")
+	}
+
+	// the measurements are already what happened so aren't bias corrected
+	config := *f.config
+	config.BiasCorrectionEnabled = false
+
+	// planned against the rates the day had rather than today's
+	hf := f.Past(d).measured(actuals)
+	hf.sc = measuredSolar(actuals)
+	hf.config = &config
+
+	d = time.Date(d.Local().Year(), d.Local().Month(), d.Local().Day(), 0, 0, 0, 0, time.Local)
+	fd, err := hf.forecast(d, 0.5)
+	if err != nil {
+		return nil, err
+	}
+	fd.Scenario = "hindsight"
+
+	return fd, nil
+}
@@ -0,0 +1,41 @@
+package forecaster
+
+import (
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/tariff"
+)
+
+// recordedRates serves the rates recorded for a past day as the current rates, so the day is planned as it would
+// have been at the time
+type recordedRates struct {
+	tc       RateProvider
+	from, to time.Time
+}
+
+func (r *recordedRates) GetRates() (*tariff.RateData, error) {
+	return r.tc.GetRatesBetween(r.from, r.to)
+}
+
+func (r *recordedRates) GetRatesBetween(from, to time.Time) (*tariff.RateData, error) {
+	return r.tc.GetRatesBetween(from, to)
+}
+
+// Past returns a forecaster sharing f's config that plans the local day of d against the tariff rates recorded for
+// it, the current rates don't cover past days
+func (f *Forecaster) Past(d time.Time) *Forecaster {
+	pf := &Forecaster{
+		sc:          f.sc,
+		gec:         f.gec,
+		tc:          f.tc,
+		config:      f.config,
+		consumption: f.consumption,
+	}
+
+	if f.tc != nil {
+		day := time.Date(d.Local().Year(), d.Local().Month(), d.Local().Day(), 0, 0, 0, 0, time.Local)
+		pf.tc = &recordedRates{tc: f.tc, from: day, to: day.AddDate(0, 0, 1)}
+	}
+
+	return pf
+}
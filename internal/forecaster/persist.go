@@ -0,0 +1,261 @@
+package forecaster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+const (
+	// configFile holds the config fields changed at runtime keyed by field name
+	configFile = "forecasterConfig.json"
+	// biasFactorsFile holds the learned bias factors, they're updated too often to count as a runtime change
+	biasFactorsFile  = "biasFactors.json"
+	biasFactorsField = "BiasFactors"
+)
+
+// WithConfigDir persists config changes made with SetConfig to dir, restoring them at startup.
+// Fields changed at runtime take precedence over the config given with WithConfig and keep their runtime value
+// until they're reset with ResetConfig. Learned bias factors are persisted separately.
+func WithConfigDir(dir string) Option {
+	return func(p *Forecaster) {
+		p.configDir = dir
+	}
+}
+
+// loadOverrides applies the persisted runtime changes and learned bias factors over the config
+func (f *Forecaster) loadOverrides() error {
+	err := f.loadBiasFactors()
+	if err != nil {
+		return err
+	}
+
+	overrides := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path.Join(f.configDir, configFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &overrides)
+		if err != nil {
+			return fmt.Errorf("error decoding config file: %w", err)
+		}
+	}
+
+	// bias factors saved with the runtime changes before they were kept apart are taken as learned
+	if value, ok := overrides[biasFactorsField]; ok {
+		if f.biasFactors == nil {
+			var bf BiasFactors
+			if json.Unmarshal(value, &bf) == nil {
+				f.biasFactors = &bf
+			}
+		}
+		delete(overrides, biasFactorsField)
+	}
+
+	config, restored := applyOverrides(*f.config, overrides)
+	if f.biasFactors != nil {
+		config.BiasFactors = *f.biasFactors
+	}
+	err = config.Validate()
+	if err != nil {
+		return fmt.Errorf("ignoring config file: %w", err)
+	}
+	f.config = &config
+	f.overrides = restored
+
+	return nil
+}
+
+// loadBiasFactors restores the learned bias factors
+func (f *Forecaster) loadBiasFactors() error {
+	data, err := os.ReadFile(path.Join(f.configDir, biasFactorsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading bias factors file: %w", err)
+	}
+
+	var bf BiasFactors
+	err = json.Unmarshal(data, &bf)
+	if err != nil {
+		return fmt.Errorf("error decoding bias factors file: %w", err)
+	}
+	f.biasFactors = &bf
+
+	return nil
+}
+
+// SetBiasFactors replaces the learned bias factors, persisting them apart from the runtime changes when a config dir
+// is set
+func (f *Forecaster) SetBiasFactors(bf BiasFactors) error {
+	c := f.GetConfig()
+	c.BiasFactors = bf
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	err = f.saveBiasFactors(bf)
+	if err != nil {
+		return err
+	}
+
+	config := f.config.clone()
+	config.BiasFactors = bf
+	f.config = &config
+
+	return nil
+}
+
+// saveBiasFactors writes out the learned bias factors, they're only kept in memory once they've been written
+func (f *Forecaster) saveBiasFactors(bf BiasFactors) error {
+	if f.configDir != "" {
+		err := writeFile(f.configDir, biasFactorsFile, mustMarshal(bf))
+		if err != nil {
+			return err
+		}
+	}
+	f.biasFactors = &bf
+
+	return nil
+}
+
+// ResetConfig drops the runtime changes, returning to the config given with WithConfig or Reload. The learned bias
+// factors are kept.
+func (f *Forecaster) ResetConfig() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.configDir != "" {
+		err := os.Remove(path.Join(f.configDir, configFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing config file: %w", err)
+		}
+	}
+	f.overrides = make(map[string]json.RawMessage)
+
+	config := f.base.clone()
+	if f.biasFactors != nil {
+		config.BiasFactors = *f.biasFactors
+	}
+	f.config = &config
+
+	return nil
+}
+
+// Reload replaces the config the runtime changes apply over, e.g. when the config file changes
+func (f *Forecaster) Reload(base Config) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	config, _ := applyOverrides(base, f.overrides)
+	if f.biasFactors != nil {
+		config.BiasFactors = *f.biasFactors
+	}
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	f.config = &config
+	f.base = base.clone()
+
+	return nil
+}
+
+// applyOverrides applies overrides to config field by field so a bad value only loses that field, returning the
+// overrides that applied
+func applyOverrides(config Config, overrides map[string]json.RawMessage) (Config, map[string]json.RawMessage) {
+	config = config.clone()
+	applied := make(map[string]json.RawMessage)
+	for field, value := range overrides {
+		err := json.Unmarshal(mustMarshal(map[string]json.RawMessage{field: value}), &config)
+		if err != nil {
+			println(fmt.Errorf("error restoring config %s: %w", field, err).Error())
+			continue
+		}
+		applied[field] = value
+	}
+
+	return config, applied
+}
+
+// saveOverrides records the fields that differ between from and to as runtime changes and writes them out,
+// replacing the file atomically so a crash mid write can't lose earlier changes. The changes are only kept in memory
+// once they've been written.
+func (f *Forecaster) saveOverrides(from, to *Config) error {
+	var before, after map[string]json.RawMessage
+	_ = json.Unmarshal(mustMarshal(from), &before)
+	_ = json.Unmarshal(mustMarshal(to), &after)
+
+	overrides := make(map[string]json.RawMessage, len(f.overrides))
+	for field, value := range f.overrides {
+		overrides[field] = value
+	}
+	for field, value := range after {
+		if field != biasFactorsField && !bytes.Equal(before[field], value) {
+			overrides[field] = value
+		}
+	}
+
+	if f.configDir == "" {
+		f.overrides = overrides
+		return nil
+	}
+
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding config file: %w", err)
+	}
+
+	err = writeFile(f.configDir, configFile, data)
+	if err != nil {
+		return err
+	}
+	f.overrides = overrides
+
+	return nil
+}
+
+// writeFile replaces the file name in dir with data atomically so a crash mid write can't lose its previous content
+func writeFile(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+
+	err = os.Rename(tmp.Name(), path.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("error replacing %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// mustMarshal marshals values that can always be encoded
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
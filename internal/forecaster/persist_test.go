@@ -0,0 +1,63 @@
+package forecaster
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestResetConfig(t *testing.T) {
+	dir := t.TempDir()
+	base := DefaultConfig()
+	f := New(nil, nil, WithConfig(&base), WithConfigDir(dir))
+
+	c := f.GetConfig()
+	c.BatteryUpperReserve = 80
+	err := f.SetConfig(*c)
+	if err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+
+	var bf BiasFactors
+	bf[5][12] = 0.9
+	err = f.SetBiasFactors(bf)
+	if err != nil {
+		t.Fatalf("SetBiasFactors() error = %v", err)
+	}
+
+	// learned factors are kept out of the runtime changes
+	data, err := os.ReadFile(path.Join(dir, configFile))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	overrides := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &overrides)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if _, ok := overrides[biasFactorsField]; ok {
+		t.Errorf("bias factors saved as a runtime change: %s", data)
+	}
+
+	// both survive a restart
+	restored := New(nil, nil, WithConfig(&base), WithConfigDir(dir)).GetConfig()
+	if restored.BatteryUpperReserve != 80 || restored.BiasFactors != bf {
+		t.Errorf("restored upper reserve %v, bias factor %v, want 80 and 0.9", restored.BatteryUpperReserve, restored.BiasFactors[5][12])
+	}
+
+	err = f.ResetConfig()
+	if err != nil {
+		t.Fatalf("ResetConfig() error = %v", err)
+	}
+	reset := f.GetConfig()
+	if reset.BatteryUpperReserve != base.BatteryUpperReserve || reset.BiasFactors != bf {
+		t.Errorf("reset upper reserve %v, bias factor %v, want %v and 0.9", reset.BatteryUpperReserve, reset.BiasFactors[5][12], base.BatteryUpperReserve)
+	}
+
+	// the reset survives a restart too
+	restored = New(nil, nil, WithConfig(&base), WithConfigDir(dir)).GetConfig()
+	if restored.BatteryUpperReserve != base.BatteryUpperReserve || restored.BiasFactors != bf {
+		t.Errorf("restored upper reserve %v, bias factor %v, want %v and 0.9", restored.BatteryUpperReserve, restored.BiasFactors[5][12], base.BatteryUpperReserve)
+	}
+}
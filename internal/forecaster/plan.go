@@ -0,0 +1,170 @@
+package forecaster
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/tariff"
+)
+
+const maxPlanIterations = 500
+
+// plan simulates the local day of t from startKwh, picking the half hours to grid charge in from the tariff's rates.
+// Working forwards through the day, each time the battery would hit its reserve the cheapest earlier half hour
+// that can still hold the charge is scheduled, provided storing energy then is cheaper than importing it when needed.
+func (f *Forecaster) plan(t time.Time, p float64, startKwh float64) (*ForecastDay, error) {
+	day := time.Date(t.Local().Year(), t.Local().Month(), t.Local().Day(), 0, 0, 0, 0, time.Local)
+	return f.planFrom(day, day, p, startKwh)
+}
+
+// planFrom plans the remainder of the local day of t from the period starting at from
+func (f *Forecaster) planFrom(t, from time.Time, p float64, startKwh float64) (*ForecastDay, error) {
+	rates, err := f.tc.GetRates()
+	if err != nil {
+		return nil, err
+	}
+
+	day := time.Date(t.Local().Year(), t.Local().Month(), t.Local().Day(), 0, 0, 0, 0, time.Local)
+	if from.Before(day) {
+		from = day
+	}
+
+	r := run{
+		from:     from,
+		to:       day.AddDate(0, 0, 1),
+		startKwh: startKwh,
+		pv:       percentile(p),
+		slots:    make(map[time.Time]*ChargeSlot),
+		rates:    rates,
+	}
+
+	slotKwh := f.config.MaxChargeKw * 0.5
+	upperKwh := (f.config.BatteryUpperReserve / 100) * f.config.StorageCapacityKwh
+	roundTrip := (1 / f.config.InverterEfficiency) * ((1 - f.config.InverterEfficiency) + 1)
+
+	// always fill up when we're paid to import
+	for pe := r.from.Add(30 * time.Minute); !pe.After(r.to); pe = pe.Add(30 * time.Minute) {
+		rate, ok := rates.At(pe.Add(-30 * time.Minute))
+		if ok && rate.ValueIncVat <= 0 {
+			r.slots[pe.UTC()] = newChargeSlot(pe, rate, slotKwh)
+		}
+	}
+
+	accepted := make(map[time.Time]bool)
+	simulation, err := f.run(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < maxPlanIterations; i++ {
+		shortIdx := -1
+		for idx, fc := range simulation.Forecasts {
+			if fc.shortfallKwh > 0.001 && !accepted[fc.PeriodEnd.UTC()] {
+				shortIdx = idx
+				break
+			}
+		}
+		if shortIdx == -1 {
+			break
+		}
+		short := simulation.Forecasts[shortIdx]
+
+		// energy stored before the battery was last full is lost, so only look back that far
+		var best *Forecast
+		var bestRate tariff.Rate
+		for idx := shortIdx - 1; idx >= 0; idx-- {
+			fc := simulation.Forecasts[idx]
+			if (fc.SOC/100)*f.config.StorageCapacityKwh >= upperKwh-0.001 {
+				break
+			}
+
+			if slot, ok := r.slots[fc.PeriodEnd.UTC()]; ok && slot.Kwh >= slotKwh-0.001 {
+				continue
+			}
+
+			rate, ok := rates.At(fc.PeriodEnd.Add(-30 * time.Minute))
+			if !ok {
+				continue
+			}
+
+			if best == nil || rate.ValueIncVat < bestRate.ValueIncVat {
+				best, bestRate = fc, rate
+			}
+		}
+
+		shortRate, ok := rates.At(short.PeriodEnd.Add(-30 * time.Minute))
+		if best == nil || (ok && bestRate.ValueIncVat*roundTrip >= shortRate.ValueIncVat) {
+			accepted[short.PeriodEnd.UTC()] = true
+			continue
+		}
+
+		slot, ok := r.slots[best.PeriodEnd.UTC()]
+		if !ok {
+			slot = newChargeSlot(best.PeriodEnd, bestRate, 0)
+			r.slots[best.PeriodEnd.UTC()] = slot
+		}
+		slot.Kwh = math.Min(slotKwh, slot.Kwh+short.shortfallKwh*((1-f.config.InverterEfficiency)+1))
+
+		simulation, err = f.run(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	simulation, err = f.runWithExports(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the target is the highest state of charge grid charging takes the battery to
+	targetKwh := startKwh
+	var slots []*ChargeSlot
+	for _, fc := range simulation.Forecasts {
+		slot, ok := r.slots[fc.PeriodEnd.UTC()]
+		if !ok {
+			continue
+		}
+
+		slots = append(slots, slot)
+		targetKwh = math.Max(targetKwh, (fc.SOC/100)*f.config.StorageCapacityKwh)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].Start.Before(slots[j].Start)
+	})
+
+	fd := f.forecastDay(day, p, simulation, targetKwh, startKwh)
+	fd.ChargeSlots = slots
+
+	return fd, nil
+}
+
+// planRange chains planned days, each starting from the previous day's end of day state of charge
+func (f *Forecaster) planRange(start time.Time, days int, p float64) (*ForecastRange, error) {
+	fr := &ForecastRange{Start: start}
+
+	startKwh := (f.config.BatteryLowerReserve / 100) * f.config.StorageCapacityKwh
+	for i := 0; i < days; i++ {
+		fd, err := f.plan(start.AddDate(0, 0, i), p, startKwh)
+		if err != nil {
+			return nil, err
+		}
+		startKwh = f.kwh(fd.EndSOC)
+
+		fr.ProductionKwh = fr.ProductionKwh + fd.ProductionKwh
+		fr.ConsumptionKwh = fr.ConsumptionKwh + fd.ConsumptionKwh
+		fr.GridImportKwh = fr.GridImportKwh + fd.GridImportKwh
+		fr.GridExportKwh = fr.GridExportKwh + fd.GridExportKwh
+		fr.Days = append(fr.Days, fd)
+	}
+
+	return fr, nil
+}
+
+func newChargeSlot(periodEnd time.Time, rate tariff.Rate, kwh float64) *ChargeSlot {
+	return &ChargeSlot{
+		Start:     periodEnd.Add(-30 * time.Minute).Local(),
+		End:       periodEnd.Local(),
+		RatePence: rate.ValueIncVat,
+		Kwh:       kwh,
+	}
+}
@@ -0,0 +1,93 @@
+package forecaster
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+type ForecastRange struct {
+	Start          time.Time
+	ProductionKwh  float64
+	ConsumptionKwh float64
+	GridImportKwh  float64
+	GridExportKwh  float64
+	Days           []*ForecastDay
+}
+
+// ForecastRange chains daily forecasts so that each day starts from the previous day's end of day state of charge,
+// topped up by that night's charge where the charge window allows
+func (f *Forecaster) ForecastRange(start time.Time, days int) (*ForecastRange, error) {
+	if days < 1 {
+		return nil, errors.New("range must cover at least one day")
+	}
+
+	start = time.Date(start.Local().Year(), start.Local().Month(), start.Local().Day(), 0, 0, 0, 0, time.Local)
+	p := f.config.RiskAppetite
+
+	if f.tc != nil {
+		return f.planRange(start, days, p)
+	}
+
+	var fds []*ForecastDay
+	for i := 0; i < days; i++ {
+		fd, err := f.Forecast(start.AddDate(0, 0, i))
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, fd)
+	}
+
+	// work backwards raising a day's target where the following night's charge window can't reach the next target
+	windowKwh := f.chargeWindowKwh()
+	for i := len(fds) - 2; i >= 0; i-- {
+		endKwh := f.kwh(fds[i].EndSOC)
+		nextTargetKwh := f.kwh(fds[i+1].RecommendedChargeTarget)
+		shortfallKwh := nextTargetKwh - (endKwh + windowKwh)
+		if shortfallKwh <= 0 {
+			continue
+		}
+
+		targetKwh := math.Min(f.kwh(fds[i].RecommendedChargeTarget)+shortfallKwh, f.config.StorageCapacityKwh)
+		fd, err := f.day(fds[i].Date, p, targetKwh, targetKwh)
+		if err != nil {
+			return nil, err
+		}
+		fd.Scenarios = fds[i].Scenarios
+		fds[i] = fd
+	}
+
+	fr := &ForecastRange{Start: start}
+	for i, fd := range fds {
+		if i > 0 {
+			prevEndKwh := f.kwh(fds[i-1].EndSOC)
+			startKwh := math.Max(prevEndKwh, f.kwh(fd.RecommendedChargeTarget))
+			startKwh = math.Min(startKwh, prevEndKwh+windowKwh)
+			startKwh = math.Min(startKwh, f.config.StorageCapacityKwh)
+
+			chained, err := f.day(fd.Date, p, f.kwh(fd.RecommendedChargeTarget), startKwh)
+			if err != nil {
+				return nil, err
+			}
+			chained.Scenarios = fd.Scenarios
+			fds[i], fd = chained, chained
+		}
+
+		fr.ProductionKwh = fr.ProductionKwh + fd.ProductionKwh
+		fr.ConsumptionKwh = fr.ConsumptionKwh + fd.ConsumptionKwh
+		fr.GridImportKwh = fr.GridImportKwh + fd.GridImportKwh
+		fr.GridExportKwh = fr.GridExportKwh + fd.GridExportKwh
+	}
+	fr.Days = fds
+
+	return fr, nil
+}
+
+// chargeWindowKwh is the most energy the battery can take on during the AC charge window
+func (f *Forecaster) chargeWindowKwh() float64 {
+	return f.config.MaxChargeKw * f.primaryWindow().Duration().Hours() * f.config.InverterEfficiency
+}
+
+func (f *Forecaster) kwh(soc float64) float64 {
+	return (soc / 100) * f.config.StorageCapacityKwh
+}
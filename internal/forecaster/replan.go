@@ -0,0 +1,67 @@
+package forecaster
+
+import (
+	"math"
+	"time"
+)
+
+// Replan re-simulates the rest of the forecast covering t from the live state of charge, keeping the day's
+// charge target and slots. The result is kept and used by ForecastNow until the next replan.
+func (f *Forecaster) Replan(t time.Time, soc float64) (*ForecastDay, error) {
+	ft := f.forecastDate(t)
+	fd, err := f.Forecast(ft)
+	if err != nil {
+		return nil, err
+	}
+
+	p := f.config.RiskAppetite
+	socKwh := f.kwh(soc)
+	periodStart := t.Truncate(30 * time.Minute)
+
+	var replanned *ForecastDay
+	if f.tc != nil {
+		replanned, err = f.planFrom(ft, periodStart, p, socKwh)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		targetKwh := f.kwh(fd.RecommendedChargeTarget)
+		r := f.dayRun(ft, targetKwh, percentile(p))
+
+		// before the day starts the battery is still to be charged to at least the target
+		startKwh := math.Max(socKwh, targetKwh)
+		if periodStart.After(r.from) {
+			r.from = periodStart
+			startKwh = socKwh
+		}
+		r.startKwh = startKwh
+
+		simulation, err := f.runWithExports(r)
+		if err != nil {
+			return nil, err
+		}
+
+		replanned = f.forecastDay(ft, p, simulation, targetKwh, startKwh)
+		replanned.ChargeSlots = fd.ChargeSlots
+	}
+	replanned.ReplannedAt = t
+	replanned.Scenarios = fd.Scenarios
+
+	f.m.Lock()
+	f.replan = replanned
+	f.m.Unlock()
+
+	return replanned, nil
+}
+
+// LastReplan returns the most recent replan if it covers the forecast for d
+func (f *Forecaster) LastReplan(d time.Time) *ForecastDay {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	if f.replan == nil || !f.replan.Date.Equal(time.Date(d.Local().Year(), d.Local().Month(), d.Local().Day(), 0, 0, 0, 0, time.Local)) {
+		return nil
+	}
+
+	return f.replan
+}
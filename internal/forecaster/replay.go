@@ -0,0 +1,115 @@
+package forecaster
+
+import (
+	"math"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+// ReplayDay is how a forecast day's charge target and slots would have played out against what was measured
+type ReplayDay struct {
+	Date            time.Time
+	Revision        time.Time // update time of the solar forecast the target was set from
+	TargetSOC       float64
+	StartSOC        float64
+	EndSOC          float64
+	ProductionKwh   float64
+	ConsumptionKwh  float64
+	GridImportKwh   float64
+	GridExportKwh   float64
+	WastedSolarKwh  float64 // solar the battery couldn't store, exported or curtailed
+	ReserveHits     int     // half hours the battery was at its reserve with load left to meet
+	ImportCostPence float64
+	ChargeSlots     []*ChargeSlot
+	Forecasts       []*Forecast
+}
+
+// Replay simulates fd's charge target, charge slots and export slots with the measured solar and consumption in place
+// of the forecast. Periods without actuals fall back to the forecast. A day chained from a previous replay starts
+// from prevEndSOC topped up by the charge window, a negative prevEndSOC starts it at the target.
+func (f *Forecaster) Replay(fd *ForecastDay, prevEndSOC float64, actuals map[time.Time]givenergy.Actual) (*ReplayDay, error) {
+	pv := func(forecast solcast.Forecast) float64 {
+		if actual, ok := actuals[forecast.PeriodEnd.UTC()]; ok {
+			return actual.SolarW / 1000
+		}
+		return percentile(f.config.RiskAppetite)(forecast)
+	}
+
+	mf := f.measured(actuals)
+	targetKwh := f.kwh(fd.RecommendedChargeTarget)
+	var r run
+	if f.tc != nil {
+		// the day is replayed against the rates it had, today's rates only cover today and tomorrow
+		rates, err := f.tc.GetRatesBetween(fd.Date, fd.Date.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+
+		startKwh := f.kwh(fd.StartSOC)
+		if prevEndSOC >= 0 {
+			startKwh = f.kwh(prevEndSOC)
+		}
+
+		r = run{
+			from:     fd.Date,
+			to:       fd.Date.AddDate(0, 0, 1),
+			startKwh: startKwh,
+			pv:       pv,
+			slots:    make(map[time.Time]*ChargeSlot),
+			rates:    rates,
+		}
+		for _, slot := range fd.ChargeSlots {
+			cs := *slot
+			r.slots[slot.End.UTC()] = &cs
+		}
+	} else {
+		startKwh := targetKwh
+		if prevEndSOC >= 0 {
+			prevEndKwh := f.kwh(prevEndSOC)
+			startKwh = math.Min(math.Max(prevEndKwh, targetKwh), prevEndKwh+f.chargeWindowKwh())
+			startKwh = math.Min(startKwh, f.config.StorageCapacityKwh)
+		}
+
+		r = f.dayRun(fd.Date, startKwh, pv)
+	}
+
+	// the planned export is replayed as it was scheduled rather than re-optimised against the actuals
+	r.exports = make(map[time.Time]float64)
+	for _, slot := range fd.ExportSlots {
+		periods := slot.End.Sub(slot.Start).Hours() * 2
+		for pe := slot.Start.Truncate(30 * time.Minute).Add(30 * time.Minute); pe.Add(-30 * time.Minute).Before(slot.End); pe = pe.Add(30 * time.Minute) {
+			r.exports[pe.UTC()] = r.exports[pe.UTC()] + (slot.Kwh*((1-f.config.InverterEfficiency)+1))/periods
+		}
+	}
+
+	simulation, err := mf.run(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rd := &ReplayDay{
+		Date:            fd.Date,
+		Revision:        fd.ForecastRevision,
+		TargetSOC:       fd.RecommendedChargeTarget,
+		StartSOC:        (r.startKwh / f.config.StorageCapacityKwh) * 100,
+		ProductionKwh:   simulation.ProductionKwh,
+		ConsumptionKwh:  simulation.ConsumptionKwh,
+		GridImportKwh:   simulation.GridImportKwh,
+		GridExportKwh:   simulation.GridExportKwh,
+		ImportCostPence: simulation.ImportCostPence,
+		ChargeSlots:     fd.ChargeSlots,
+		Forecasts:       simulation.Forecasts,
+	}
+	rd.EndSOC = rd.StartSOC
+	for _, fc := range simulation.Forecasts {
+		rd.WastedSolarKwh = rd.WastedSolarKwh + fc.spilledKwh
+		if fc.shortfallKwh > 0.001 {
+			rd.ReserveHits++
+		}
+		rd.EndSOC = fc.SOC
+	}
+
+	return rd, nil
+}
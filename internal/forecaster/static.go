@@ -0,0 +1,29 @@
+package forecaster
+
+import (
+	"errors"
+
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+// StaticForecast is a solar forecast provider serving a fixed forecast, such as a historical revision or measured
+// production, that can't be updated or set
+type StaticForecast struct {
+	fcd *solcast.ForecastData
+}
+
+func NewStaticForecast(fcd *solcast.ForecastData) *StaticForecast {
+	return &StaticForecast{fcd: fcd}
+}
+
+func (s *StaticForecast) GetForecast() (*solcast.ForecastData, error) {
+	return s.fcd, nil
+}
+
+func (s *StaticForecast) UpdateForecast() error {
+	return errors.New("a static forecast can't be updated")
+}
+
+func (s *StaticForecast) SetForecast(_ solcast.ForecastData) error {
+	return errors.New("a static forecast can't be set")
+}
@@ -0,0 +1,94 @@
+package forecaster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FieldError describes why a config field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a config
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	var msgs []string
+	for _, fe := range ve {
+		msgs = append(msgs, fmt.Sprintf("%s %s", fe.Field, fe.Message))
+	}
+	return "invalid config: " + strings.Join(msgs, ", ")
+}
+
+// Validate checks the config's ranges and the rules between fields, returning a ValidationError when it's invalid
+func (c Config) Validate() error {
+	var ve ValidationError
+	check := func(ok bool, field, format string, a ...interface{}) {
+		if !ok {
+			ve = append(ve, FieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+		}
+	}
+
+	check(c.StorageCapacityKwh > 0, "StorageCapacityKwh", "must be greater than 0")
+	check(c.InverterEfficiency > 0 && c.InverterEfficiency <= 1, "InverterEfficiency", "must be greater than 0 and at most 1")
+	check(c.BatteryLowerReserve >= 0 && c.BatteryLowerReserve <= 100, "BatteryLowerReserve", "must be between 0 and 100")
+	check(c.BatteryUpperReserve >= 0 && c.BatteryUpperReserve <= 100, "BatteryUpperReserve", "must be between 0 and 100")
+	check(c.BatteryLowerReserve <= c.BatteryUpperReserve, "BatteryLowerReserve", "must not be above BatteryUpperReserve (%g)", c.BatteryUpperReserve)
+	check(c.MaxChargeKw > 0, "MaxChargeKw", "must be greater than 0")
+	check(c.MaxDischargeKw > 0, "MaxDischargeKw", "must be greater than 0")
+	check(c.AvgConsumptionKw >= 0, "AvgConsumptionKw", "must not be negative, 0 uses the consumption averages")
+	check(c.RiskAppetite >= 0 && c.RiskAppetite <= 1, "RiskAppetite", "must be between 0 and 1")
+	check(c.LookaheadDays >= 0 && c.LookaheadDays <= 6, "LookaheadDays", "must be between 0 and 6, the solar forecast covers a week")
+	check(c.ExportLimitKw >= 0, "ExportLimitKw", "must not be negative, 0 is no limit")
+
+	for i, w := range c.ChargeWindows {
+		field := fmt.Sprintf("ChargeWindows[%d]", i)
+		check(minuteOfDay(w.Start) != minuteOfDay(w.End), field, "must not start and end at the same time")
+		check(w.TargetSOC >= 0 && w.TargetSOC <= 100, field, "TargetSOC must be between 0 and 100")
+
+		for j, other := range c.ChargeWindows[:i] {
+			if w.Local != other.Local {
+				continue
+			}
+			check(!overlaps(w.Start, w.End, other.Start, other.End), field, "overlaps ChargeWindows[%d]", j)
+		}
+	}
+
+	for i, w := range c.ExportWindows {
+		field := fmt.Sprintf("ExportWindows[%d]", i)
+		check(minuteOfDay(w.Start) != minuteOfDay(w.End), field, "must not start and end at the same time")
+		check(w.RatePence >= 0, field, "RatePence must not be negative")
+	}
+
+	for month := range c.BiasFactors {
+		for hour, factor := range c.BiasFactors[month] {
+			check(factor >= 0, fmt.Sprintf("BiasFactors[%d][%d]", month, hour), "must not be negative, 0 is no correction")
+		}
+	}
+
+	if len(ve) > 0 {
+		return ve
+	}
+
+	return nil
+}
+
+// overlaps reports whether two daily windows, either of which may cross midnight, share any time
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	within := func(m, start, end int) bool {
+		if start < end {
+			return m >= start && m < end
+		}
+		return m >= start || m < end
+	}
+
+	as, ae, bs, be := minuteOfDay(aStart), minuteOfDay(aEnd), minuteOfDay(bStart), minuteOfDay(bEnd)
+	return within(as, bs, be) || within(bs, as, ae)
+}
+
+func minuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
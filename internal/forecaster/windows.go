@@ -0,0 +1,242 @@
+package forecaster
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChargeWindow is a daily period the inverter AC charges from the grid in, only the hour and minute of Start and End are used.
+// Windows may cross midnight.
+type ChargeWindow struct {
+	Start     time.Time
+	End       time.Time
+	Local     bool    // times follow local time, otherwise they're UTC and don't shift with BST
+	TargetSOC float64 // SOC to charge to, 0 charges to the recommended target
+}
+
+// On returns the occurrence of the window ending on the local date of d
+func (w ChargeWindow) On(d time.Time) (time.Time, time.Time) {
+	loc := time.UTC
+	if w.Local {
+		loc = time.Local
+	}
+
+	d = d.Local()
+	start := time.Date(d.Year(), d.Month(), d.Day(), w.Start.Hour(), w.Start.Minute(), 0, 0, loc)
+	end := time.Date(d.Year(), d.Month(), d.Day(), w.End.Hour(), w.End.Minute(), 0, 0, loc)
+	if !start.Before(end) {
+		start = start.AddDate(0, 0, -1)
+	}
+
+	return start, end
+}
+
+func (w ChargeWindow) Duration() time.Duration {
+	start, end := w.On(time.Now())
+	return end.Sub(start)
+}
+
+// ParseChargeWindows parses a comma separated list of windows in the form 00:35-07:25 with an optional @SOC suffix, e.g. 13:00-16:00@100
+func ParseChargeWindows(s string, local bool) ([]ChargeWindow, error) {
+	var windows []ChargeWindow
+	for _, ws := range strings.Split(s, ",") {
+		start, end, soc, err := parseWindow(ws)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, ChargeWindow{
+			Start:     start,
+			End:       end,
+			Local:     local,
+			TargetSOC: soc,
+		})
+	}
+
+	return windows, nil
+}
+
+// parseWindow parses a window in the form 00:35-07:25 with an optional @value suffix
+func parseWindow(ws string) (time.Time, time.Time, float64, error) {
+	ws = strings.TrimSpace(ws)
+
+	var value float64
+	if i := strings.Index(ws, "@"); i != -1 {
+		v, err := strconv.ParseFloat(ws[i+1:], 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid value in window %s: %w", ws, err)
+		}
+		value = v
+		ws = ws[:i]
+	}
+
+	times := strings.Split(ws, "-")
+	if len(times) != 2 {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid window %s", ws)
+	}
+
+	start, err := time.Parse("15:04", times[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid start in window %s: %w", ws, err)
+	}
+	end, err := time.Parse("15:04", times[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid end in window %s: %w", ws, err)
+	}
+
+	return time.Date(1, 1, 1, start.Hour(), start.Minute(), 0, 0, time.UTC), time.Date(1, 1, 1, end.Hour(), end.Minute(), 0, 0, time.UTC), value, nil
+}
+
+// primaryWindow is the window the recommended charge target is for, the simulated day runs from its end until its next start
+func (f *Forecaster) primaryWindow() ChargeWindow {
+	if len(f.config.ChargeWindows) == 0 {
+		return defaultChargeWindow()
+	}
+
+	return f.config.ChargeWindows[0]
+}
+
+func defaultChargeWindow() ChargeWindow {
+	// Eco 7 times in the UK do not shift with BST
+	return ChargeWindow{
+		Start: time.Date(1, 1, 1, 0, 35, 0, 0, time.UTC),
+		End:   time.Date(1, 1, 1, 7, 25, 0, 0, time.UTC),
+	}
+}
+
+// windowSlots returns the grid charge slots for the secondary windows falling between from and to keyed by period end,
+// along with a slot per window occurrence. Windows without a target charge to targetKwh.
+func (f *Forecaster) windowSlots(from, to time.Time, targetKwh float64) (map[time.Time]*ChargeSlot, []*ChargeSlot) {
+	slots := make(map[time.Time]*ChargeSlot)
+	var windows []*ChargeSlot
+	if len(f.config.ChargeWindows) < 2 {
+		return slots, windows
+	}
+
+	for _, w := range f.config.ChargeWindows[1:] {
+		for d := from.AddDate(0, 0, -1); !d.After(to.AddDate(0, 0, 1)); d = d.AddDate(0, 0, 1) {
+			start, end := w.On(d)
+			if !end.After(from) || !start.Before(to) {
+				continue
+			}
+
+			targetSOC := w.TargetSOC
+			if targetSOC == 0 {
+				targetSOC = (targetKwh / f.config.StorageCapacityKwh) * 100
+			}
+
+			windows = append(windows, &ChargeSlot{
+				Start:     start.Local(),
+				End:       end.Local(),
+				TargetSOC: w.TargetSOC,
+			})
+
+			for pe := start.Truncate(30 * time.Minute).Add(30 * time.Minute); pe.Add(-30 * time.Minute).Before(end); pe = pe.Add(30 * time.Minute) {
+				periodStart := pe.Add(-30 * time.Minute)
+				overlap := minTime(pe, end).Sub(maxTime(periodStart, start))
+				if overlap <= 0 {
+					continue
+				}
+
+				slots[pe.UTC()] = &ChargeSlot{
+					Start:     periodStart.Local(),
+					End:       pe.Local(),
+					TargetSOC: targetSOC,
+					Kwh:       f.config.MaxChargeKw * overlap.Hours(),
+				}
+			}
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Start.Before(windows[j].Start)
+	})
+
+	return slots, windows
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// InverterSlots merges the day's charge slots into contiguous windows with their SOC limits for writing to the inverter
+func (f *Forecaster) InverterSlots(fd *ForecastDay) []*ChargeSlot {
+	var merged []*ChargeSlot
+	for _, slot := range fd.ChargeSlots {
+		targetSOC := slot.TargetSOC
+		if targetSOC == 0 {
+			targetSOC = fd.RecommendedChargeTarget
+		}
+
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			if last.End.Equal(slot.Start) && last.TargetSOC == targetSOC {
+				last.End = slot.End
+				last.Kwh = last.Kwh + slot.Kwh
+				continue
+			}
+		}
+
+		merged = append(merged, &ChargeSlot{
+			Start:     slot.Start,
+			End:       slot.End,
+			RatePence: slot.RatePence,
+			Kwh:       slot.Kwh,
+			TargetSOC: targetSOC,
+		})
+	}
+
+	return merged
+}
+
+// FitSlots merges the slots separated by the shortest gaps until there are at most max, so the charge planned for
+// every slot still happens. The merged slot charges to the higher of the two targets. When the inverter has a single
+// target shared by its slots they all charge to the highest.
+func FitSlots(slots []*ChargeSlot, max int, sharedTarget bool) []*ChargeSlot {
+	fitted := make([]*ChargeSlot, 0, len(slots))
+	for _, slot := range slots {
+		s := *slot
+		fitted = append(fitted, &s)
+	}
+
+	for max > 0 && len(fitted) > max {
+		shortest := 1
+		for i := 2; i < len(fitted); i++ {
+			if fitted[i].Start.Sub(fitted[i-1].End) < fitted[shortest].Start.Sub(fitted[shortest-1].End) {
+				shortest = i
+			}
+		}
+
+		prev, next := fitted[shortest-1], fitted[shortest]
+		prev.End = next.End
+		prev.Kwh = prev.Kwh + next.Kwh
+		prev.TargetSOC = math.Max(prev.TargetSOC, next.TargetSOC)
+		fitted = append(fitted[:shortest], fitted[shortest+1:]...)
+	}
+
+	if sharedTarget {
+		var target float64
+		for _, slot := range fitted {
+			target = math.Max(target, slot.TargetSOC)
+		}
+		for _, slot := range fitted {
+			slot.TargetSOC = target
+		}
+	}
+
+	return fitted
+}
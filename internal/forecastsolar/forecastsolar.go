@@ -0,0 +1,259 @@
+// Package forecastsolar estimates PV production from the Forecast.Solar API, normalised to the solcast forecast shape.
+// Forecast.Solar gives a single estimate so the P10 and P90 estimates match the P50 estimate.
+package forecastsolar
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+type Client struct {
+	m           sync.RWMutex
+	c           *http.Client
+	baseURL     string
+	apiKey      string
+	latitude    float64
+	longitude   float64
+	declination float64
+	azimuth     float64
+	kwp         float64
+	cacheDir    string
+	data        *solcast.ForecastData
+	history     *solcast.History
+}
+
+const (
+	dataCacheFile = "forecastSolarData.gob"
+	period        = 30 * time.Minute
+)
+
+// NewClient creates a client for the plane at latitude and longitude tilted declination degrees from horizontal and facing
+// azimuth degrees from south (-90 east, 90 west), apiKey may be empty to use the public rate limited API.
+// Every update is recorded to history when it isn't nil.
+func NewClient(apiKey string, latitude, longitude, declination, azimuth, kwp float64, cacheDir string, history *solcast.History) *Client {
+	return &Client{
+		c:           http.DefaultClient,
+		baseURL:     "https://api.forecast.solar",
+		apiKey:      apiKey,
+		latitude:    latitude,
+		longitude:   longitude,
+		declination: declination,
+		azimuth:     azimuth,
+		kwp:         kwp,
+		cacheDir:    cacheDir,
+		history:     history,
+	}
+}
+
+type EstimateResponse struct {
+	Result struct {
+		WattHoursPeriod map[string]float64 `json:"watt_hours_period"`
+	} `json:"result"`
+	Message struct {
+		Code int    `json:"code"`
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+func (c *Client) writeDataCache(data *solcast.ForecastData) error {
+	dataCacheFilePath := path.Join(c.cacheDir, dataCacheFile)
+	f, err := os.Create(dataCacheFilePath)
+	if err != nil {
+		return fmt.Errorf("error creating data cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(data)
+	if err != nil {
+		return fmt.Errorf("error encoding data cache file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) readDataCache() (*solcast.ForecastData, error) {
+	dataCacheFilePath := path.Join(c.cacheDir, dataCacheFile)
+	f, err := os.Open(dataCacheFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening data cache file: %w", err)
+	}
+	defer f.Close()
+
+	data := &solcast.ForecastData{}
+	err = gob.NewDecoder(f).Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding data cache file: %w", err)
+	}
+
+	return data, nil
+}
+
+func (c *Client) SetForecast(fcd solcast.ForecastData) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	sort.Slice(fcd.Forecasts, func(i, j int) bool {
+		return fcd.Forecasts[i].PeriodEnd.Before(fcd.Forecasts[j].PeriodEnd)
+	})
+
+	if fcd.UpdatedAt.IsZero() {
+		fcd.UpdatedAt = time.Now().UTC()
+	}
+
+	c.data = &fcd
+	if c.cacheDir != "" {
+		err := c.writeDataCache(&fcd)
+		if err != nil {
+			println(fmt.Errorf("error updating data cache: %w", err).Error())
+		}
+	}
+
+	if c.history != nil {
+		err := c.history.Record(fcd)
+		if err != nil {
+			println(fmt.Errorf("error recording forecast revision: %w", err).Error())
+		}
+	}
+	return nil
+}
+
+func (c *Client) UpdateForecast() error {
+	url := fmt.Sprintf("%s/estimate/%g/%g/%g/%g/%g?time=utc", c.baseURL, c.latitude, c.longitude, c.declination, c.azimuth, c.kwp)
+	if c.apiKey != "" {
+		url = fmt.Sprintf("%s/%s/estimate/%g/%g/%g/%g/%g?time=utc", c.baseURL, c.apiKey, c.latitude, c.longitude, c.declination, c.azimuth, c.kwp)
+	}
+
+	get, err := c.c.Get(url)
+	if err != nil {
+		return err
+	}
+	defer get.Body.Close()
+
+	if get.StatusCode != http.StatusOK {
+		// errors usually explain themselves in the message but aren't always json, such as from a proxy
+		body, _ := ioutil.ReadAll(get.Body)
+		var errorResponse EstimateResponse
+		if json.Unmarshal(body, &errorResponse) == nil && errorResponse.Message.Text != "" {
+			return fmt.Errorf("unexpected response code %d: %s", get.StatusCode, errorResponse.Message.Text)
+		}
+		return fmt.Errorf("unexpected response code %d: %s", get.StatusCode, string(body))
+	}
+
+	var estimateResponse EstimateResponse
+	err = json.NewDecoder(get.Body).Decode(&estimateResponse)
+	if err != nil {
+		return err
+	}
+
+	fcd, err := normalise(estimateResponse.Result.WattHoursPeriod)
+	if err != nil {
+		return err
+	}
+
+	fcd.UpdatedAt = time.Now().UTC()
+	return c.SetForecast(*fcd)
+}
+
+// normalise spreads the energy of each reported period evenly over its duration and totals it into half hour periods.
+// Every half hour of the days covered is included so nights are forecast as zero production.
+func normalise(whp map[string]float64) (*solcast.ForecastData, error) {
+	type point struct {
+		t  time.Time
+		wh float64
+	}
+
+	var points []point
+	for ts, wh := range whp {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid period %s: %w", ts, err)
+		}
+		points = append(points, point{t: t.UTC(), wh: wh})
+	}
+	if len(points) == 0 {
+		return nil, errors.New("no forecast periods returned")
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].t.Before(points[j].t)
+	})
+
+	whs := make(map[time.Time]float64)
+	for i := 1; i < len(points); i++ {
+		start, end := points[i-1].t, points[i].t
+		// the first period of each day starts at sunrise, the energy reported overnight is always zero
+		if end.Sub(start) > 2*time.Hour || points[i].wh == 0 {
+			continue
+		}
+
+		for pe := start.Truncate(period).Add(period); pe.Add(-period).Before(end); pe = pe.Add(period) {
+			ps := pe.Add(-period)
+			overlap := minTime(pe, end).Sub(maxTime(ps, start))
+			if overlap <= 0 {
+				continue
+			}
+			whs[pe] = whs[pe] + points[i].wh*(overlap.Hours()/end.Sub(start).Hours())
+		}
+	}
+
+	from := points[0].t.Truncate(24 * time.Hour)
+	to := points[len(points)-1].t.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	fcd := &solcast.ForecastData{Forecasts: []solcast.Forecast{}}
+	for pe := from.Add(period); !pe.After(to); pe = pe.Add(period) {
+		kw := (whs[pe] / 1000) / period.Hours()
+		fcd.Forecasts = append(fcd.Forecasts, solcast.Forecast{
+			PvEstimate:   kw,
+			PvEstimate10: kw,
+			PvEstimate90: kw,
+			PeriodEnd:    pe,
+			Period:       "PT30M",
+		})
+	}
+
+	return fcd, nil
+}
+
+func (c *Client) GetForecast() (*solcast.ForecastData, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.data == nil {
+		if c.cacheDir == "" {
+			return nil, errors.New("no forecast.solar forecast data available")
+		}
+
+		fcd, err := c.readDataCache()
+		if err != nil {
+			return nil, fmt.Errorf("no forecast.solar forecast data available: %w", err)
+		}
+		c.data = fcd
+	}
+
+	data := *c.data
+	return &data, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,142 @@
+package forecastsolar
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, apiKey string, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := NewClient(apiKey, 51.5, -0.1, 35, 0, 4, "", nil)
+	c.baseURL = srv.URL
+	return c
+}
+
+const estimate = `{
+  "result": {
+    "watt_hours_period": {
+      "2023-06-01T04:45:00Z": 0,
+      "2023-06-01T05:00:00Z": 100,
+      "2023-06-01T06:00:00Z": 1000,
+      "2023-06-01T20:00:00Z": 0
+    }
+  },
+  "message": {"code": 0, "type": "success", "text": ""}
+}`
+
+func TestUpdateForecast(t *testing.T) {
+	c := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/estimate/51.5/-0.1/35/0/4" || r.URL.Query().Get("time") != "utc" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(estimate))
+	})
+
+	err := c.UpdateForecast()
+	if err != nil {
+		t.Fatalf("UpdateForecast() error = %v", err)
+	}
+
+	fcd, err := c.GetForecast()
+	if err != nil {
+		t.Fatalf("GetForecast() error = %v", err)
+	}
+	if fcd.UpdatedAt.IsZero() {
+		t.Error("UpdatedAt isn't set")
+	}
+
+	// every half hour of the day is forecast, nights as zero
+	if len(fcd.Forecasts) != 48 {
+		t.Fatalf("got %d forecasts, want 48", len(fcd.Forecasts))
+	}
+	if first := fcd.Forecasts[0].PeriodEnd; !first.Equal(time.Date(2023, 6, 1, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("first period ends %s, want 00:30", first)
+	}
+
+	want := map[time.Time]float64{
+		time.Date(2023, 6, 1, 4, 30, 0, 0, time.UTC): 0,
+		time.Date(2023, 6, 1, 5, 0, 0, 0, time.UTC):  0.2, // 100Wh over the quarter hour from sunrise
+		time.Date(2023, 6, 1, 5, 30, 0, 0, time.UTC): 1,   // the hour to 06:00 is spread over its half hours
+		time.Date(2023, 6, 1, 6, 0, 0, 0, time.UTC):  1,
+		time.Date(2023, 6, 1, 6, 30, 0, 0, time.UTC): 0,
+		time.Date(2023, 6, 1, 20, 0, 0, 0, time.UTC): 0,
+	}
+	for _, forecast := range fcd.Forecasts {
+		kw, ok := want[forecast.PeriodEnd]
+		if !ok {
+			continue
+		}
+		if math.Abs(forecast.PvEstimate-kw) > 1e-9 {
+			t.Errorf("period ending %s estimate = %v, want %v", forecast.PeriodEnd.Format("15:04"), forecast.PvEstimate, kw)
+		}
+		if forecast.PvEstimate10 != forecast.PvEstimate || forecast.PvEstimate90 != forecast.PvEstimate {
+			t.Errorf("period ending %s estimates %v/%v/%v, want them equal", forecast.PeriodEnd.Format("15:04"), forecast.PvEstimate10, forecast.PvEstimate, forecast.PvEstimate90)
+		}
+		if forecast.Period != "PT30M" {
+			t.Errorf("period ending %s period = %s, want PT30M", forecast.PeriodEnd.Format("15:04"), forecast.Period)
+		}
+	}
+}
+
+func TestUpdateForecastAPIKey(t *testing.T) {
+	c := newTestClient(t, "key", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/key/estimate/51.5/-0.1/35/0/4" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(estimate))
+	})
+
+	err := c.UpdateForecast()
+	if err != nil {
+		t.Fatalf("UpdateForecast() error = %v", err)
+	}
+}
+
+func TestUpdateForecastError(t *testing.T) {
+	c := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"result": null, "message": {"code": 429, "type": "error", "text": "Rate limit for API calls reached."}}`))
+	})
+
+	err := c.UpdateForecast()
+	if err == nil || !strings.Contains(err.Error(), "Rate limit") {
+		t.Fatalf("UpdateForecast() error = %v, want the rate limit message", err)
+	}
+
+	_, err = c.GetForecast()
+	if err == nil {
+		t.Error("GetForecast() error = nil, want no forecast after a failed update")
+	}
+}
+
+func TestUpdateForecastErrorNotJSON(t *testing.T) {
+	c := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>502 Bad Gateway</html>"))
+	})
+
+	err := c.UpdateForecast()
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("UpdateForecast() error = %v, want the response code", err)
+	}
+}
+
+func TestNormaliseNoPeriods(t *testing.T) {
+	_, err := normalise(map[string]float64{})
+	if err == nil {
+		t.Error("normalise() error = nil, want an error without periods")
+	}
+
+	_, err = normalise(map[string]float64{"2023-06-01 05:00:00": 100})
+	if err == nil {
+		t.Error("normalise() error = nil, want an error for a period that isn't RFC3339")
+	}
+}
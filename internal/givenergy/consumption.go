@@ -0,0 +1,279 @@
+package givenergy
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	averagesCacheFile = "consumptionAverages.gob"
+	slotFormat        = "15:04"
+	// dataPointsPageSize is the most data points the api returns per page, enough for a day in one request
+	dataPointsPageSize = 2880
+)
+
+type DataPoint struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Power  struct {
+		Solar struct {
+			Power int `json:"power"`
+		} `json:"solar"`
+		Grid struct {
+			Power int `json:"power"`
+		} `json:"grid"`
+		Battery struct {
+			Percent int `json:"percent"`
+			Power   int `json:"power"`
+		} `json:"battery"`
+		Consumption struct {
+			Power int `json:"power"`
+		} `json:"consumption"`
+	} `json:"power"`
+}
+
+type Actual struct {
+	SolarW       float64 `json:"solar_w"`
+	ConsumptionW float64 `json:"consumption_w"`
+}
+
+type ConsumptionAverages struct {
+	Weekday   map[string]float64 `json:"weekday"`
+	Weekend   map[string]float64 `json:"weekend"`
+	Days      int                `json:"days"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// At returns the average consumption in W for the half hour period ending at t
+func (ca *ConsumptionAverages) At(t time.Time) float64 {
+	start := t.Add(-30 * time.Minute).Local()
+	slot := start.Format(slotFormat)
+
+	profile, fallback := ca.Weekday, ca.Weekend
+	if isWeekend(start) {
+		profile, fallback = ca.Weekend, ca.Weekday
+	}
+
+	if v, ok := profile[slot]; ok {
+		return v
+	}
+
+	return fallback[slot]
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+func (c *Client) GetDataPoints(serial string, d time.Time) ([]DataPoint, error) {
+	type DataPointsResponse struct {
+		Data []DataPoint `json:"data"`
+		Meta struct {
+			CurrentPage int `json:"current_page"`
+			LastPage    int `json:"last_page"`
+		} `json:"meta"`
+	}
+
+	var dps []DataPoint
+	for page := 1; ; page++ {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/inverter/%s/data-points/%s?page=%d&pageSize=%d", geCloudV1BaseURL, serial, d.Format("2006-01-02"), page, dataPointsPageSize), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		dpr := DataPointsResponse{}
+		err = json.Unmarshal(body, &dpr)
+		if err != nil {
+			return nil, err
+		}
+
+		dps = append(dps, dpr.Data...)
+		if dpr.Meta.CurrentPage >= dpr.Meta.LastPage {
+			break
+		}
+	}
+
+	return dps, nil
+}
+
+// GetActuals returns the mean solar and consumption power for each period of day d, keyed by period end and summed across inverters
+func (c *Client) GetActuals(d time.Time, period time.Duration) (map[time.Time]Actual, error) {
+	type accumulator struct {
+		solarW, consumptionW float64
+		n                    int
+	}
+
+	actuals := make(map[time.Time]Actual)
+	for _, serial := range c.serials {
+		dps, err := c.GetDataPoints(serial, d)
+		if err != nil {
+			return nil, fmt.Errorf("error getting data points for %s: %w", serial, err)
+		}
+
+		acc := make(map[time.Time]*accumulator)
+		for _, dp := range dps {
+			pe := dp.Time.Truncate(period).Add(period).UTC()
+			a, ok := acc[pe]
+			if !ok {
+				a = &accumulator{}
+				acc[pe] = a
+			}
+			a.solarW = a.solarW + float64(dp.Power.Solar.Power)
+			a.consumptionW = a.consumptionW + float64(dp.Power.Consumption.Power)
+			a.n++
+		}
+
+		for pe, a := range acc {
+			actual := actuals[pe]
+			actual.SolarW = actual.SolarW + a.solarW/float64(a.n)
+			actual.ConsumptionW = actual.ConsumptionW + a.consumptionW/float64(a.n)
+			actuals[pe] = actual
+		}
+	}
+
+	return actuals, nil
+}
+
+func (c *Client) UpdateConsumptionAverages() error {
+	type accumulator struct {
+		totalW float64
+		n      int
+	}
+
+	weekday := make(map[string]*accumulator)
+	weekend := make(map[string]*accumulator)
+
+	now := time.Now().UTC()
+	today := time.Date(now.Local().Year(), now.Local().Month(), now.Local().Day(), 0, 0, 0, 0, time.Local)
+	for i := 1; i <= c.consumptionDays; i++ {
+		d := today.AddDate(0, 0, -i)
+		actuals, err := c.GetActuals(d, 30*time.Minute)
+		if err != nil {
+			return err
+		}
+
+		profile := weekday
+		if isWeekend(d) {
+			profile = weekend
+		}
+
+		for pe, actual := range actuals {
+			slot := pe.Add(-30 * time.Minute).Local().Format(slotFormat)
+			a, ok := profile[slot]
+			if !ok {
+				a = &accumulator{}
+				profile[slot] = a
+			}
+			a.totalW = a.totalW + actual.ConsumptionW
+			a.n++
+		}
+	}
+
+	if len(weekday) == 0 && len(weekend) == 0 {
+		return errors.New("no consumption data available to build averages")
+	}
+
+	averages := func(acc map[string]*accumulator) map[string]float64 {
+		avg := make(map[string]float64)
+		for slot, a := range acc {
+			avg[slot] = a.totalW / float64(a.n)
+		}
+		return avg
+	}
+
+	return c.SetConsumptionAverages(ConsumptionAverages{
+		Weekday:   averages(weekday),
+		Weekend:   averages(weekend),
+		Days:      c.consumptionDays,
+		UpdatedAt: now,
+	})
+}
+
+func (c *Client) SetConsumptionAverages(ca ConsumptionAverages) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.averages = &ca
+	if c.cacheDir != "" {
+		err := c.writeAveragesCache(&ca)
+		if err != nil {
+			println(fmt.Errorf("error updating consumption averages cache: %w", err).Error())
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) GetConsumptionAverages() (*ConsumptionAverages, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.averages == nil {
+		if c.cacheDir == "" {
+			return nil, errors.New("no consumption averages available")
+		}
+
+		ca, err := c.readAveragesCache()
+		if err != nil {
+			return nil, fmt.Errorf("no consumption averages available: %w", err)
+		}
+		c.averages = ca
+	}
+
+	averages := *c.averages
+	return &averages, nil
+}
+
+func (c *Client) writeAveragesCache(ca *ConsumptionAverages) error {
+	f, err := os.Create(path.Join(c.cacheDir, averagesCacheFile))
+	if err != nil {
+		return fmt.Errorf("error creating consumption averages cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(ca)
+	if err != nil {
+		return fmt.Errorf("error encoding consumption averages cache file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) readAveragesCache() (*ConsumptionAverages, error) {
+	f, err := os.Open(path.Join(c.cacheDir, averagesCacheFile))
+	if err != nil {
+		return nil, fmt.Errorf("error opening consumption averages cache file: %w", err)
+	}
+	defer f.Close()
+
+	ca := &ConsumptionAverages{}
+	err = gob.NewDecoder(f).Decode(ca)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding consumption averages cache file: %w", err)
+	}
+
+	return ca, nil
+}
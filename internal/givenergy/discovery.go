@@ -0,0 +1,330 @@
+package givenergy
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	discoveryCacheFile    = "discovery.gob"
+	writtenChargeSlotFile = "writtenChargeSlot.gob"
+
+	BatteryReserveSettingID        = 71
+	BatteryChargePowerSettingID    = 72
+	BatteryDischargePowerSettingID = 73
+)
+
+// Discovery is the battery and inverter parameters read from the cloud, zero values weren't discovered
+type Discovery struct {
+	StorageCapacityKwh float64   `json:"storage_capacity_kwh"` // nominal capacity summed across inverters
+	BatteryReserve     float64   `json:"battery_reserve"`
+	ChargeWindow       string    `json:"charge_window"` // first AC charge slot in local time, e.g. 00:30-04:30
+	MaxChargeKw        float64   `json:"max_charge_kw"` // summed across inverters
+	MaxDischargeKw     float64   `json:"max_discharge_kw"`
+	DiscoveredAt       time.Time `json:"discovered_at"`
+}
+
+type CommunicationDevice struct {
+	SerialNumber string `json:"serial_number"`
+	Inverter     struct {
+		Serial string `json:"serial"`
+		Info   struct {
+			Model         string  `json:"model"`
+			MaxChargeRate float64 `json:"max_charge_rate"`
+			Battery       struct {
+				NominalCapacity float64 `json:"nominal_capacity"`
+				NominalVoltage  float64 `json:"nominal_voltage"`
+			} `json:"battery"`
+		} `json:"info"`
+	} `json:"inverter"`
+}
+
+// Discover reads the battery capacity and the reserve, charge slot and battery power settings of the inverters,
+// keeping the result for GetDiscovery. Settings that can't be read are logged and left undiscovered.
+func (c *Client) Discover() (*Discovery, error) {
+	devices, err := c.GetCommunicationDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Discovery{
+		DiscoveredAt: time.Now().UTC(),
+	}
+	dischargeUndiscovered := false
+	for i, serial := range c.serials {
+		device, ok := devices[serial]
+		if !ok {
+			return nil, fmt.Errorf("inverter %s not found in the account's devices", serial)
+		}
+
+		info := device.Inverter.Info
+		d.StorageCapacityKwh = d.StorageCapacityKwh + info.Battery.NominalCapacity*info.Battery.NominalVoltage/1000
+
+		// the device info only has the charge rate, the discharge rate is left undiscovered unless the setting is read
+		chargeKw, dischargeKw := info.MaxChargeRate/1000, 0.0
+		// the ems doesn't have the inverter settings
+		if !c.ems {
+			// the battery power settings limit the rate below the inverter's maximum
+			if w := c.discoverFloat(serial, "Battery Charge Power", BatteryChargePowerSettingID); w > 0 && (chargeKw == 0 || w/1000 < chargeKw) {
+				chargeKw = w / 1000
+			}
+			if w := c.discoverFloat(serial, "Battery Discharge Power", BatteryDischargePowerSettingID); w > 0 {
+				dischargeKw = w / 1000
+			}
+
+			// the reserve and charge slot are shared when inverters are paired, so the first inverter's apply
+			if i == 0 {
+				d.BatteryReserve = c.discoverFloat(serial, "Battery Reserve % Limit", BatteryReserveSettingID)
+				d.ChargeWindow = c.discoverChargeWindow(serial)
+
+				// slot 1 holds our own plan once we've written it, which mustn't become the day's charge window
+				if d.ChargeWindow != "" && d.ChargeWindow == c.writtenChargeWindow() {
+					d.ChargeWindow = ""
+					if prev, err := c.GetDiscovery(); err == nil {
+						d.ChargeWindow = prev.ChargeWindow
+					}
+				}
+			}
+		}
+		d.MaxChargeKw = d.MaxChargeKw + chargeKw
+
+		// a sum missing an inverter's rate would understate it
+		if dischargeKw == 0 {
+			dischargeUndiscovered = true
+		}
+		d.MaxDischargeKw = d.MaxDischargeKw + dischargeKw
+	}
+	if dischargeUndiscovered {
+		d.MaxDischargeKw = 0
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.discovery = d
+	if c.cacheDir != "" {
+		err := c.writeDiscoveryCache(d)
+		if err != nil {
+			println(fmt.Errorf("error updating discovery cache: %w", err).Error())
+		}
+	}
+
+	discovery := *d
+	return &discovery, nil
+}
+
+// GetDiscovery returns the last discovery, restoring it from the cache after a restart
+func (c *Client) GetDiscovery() (*Discovery, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.discovery == nil {
+		if c.cacheDir == "" {
+			return nil, errors.New("no discovery available")
+		}
+
+		d, err := c.readDiscoveryCache()
+		if err != nil {
+			return nil, fmt.Errorf("no discovery available: %w", err)
+		}
+		c.discovery = d
+	}
+
+	discovery := *c.discovery
+	return &discovery, nil
+}
+
+// GetCommunicationDevices returns the account's devices keyed by the serial of their inverter
+func (c *Client) GetCommunicationDevices() (map[string]CommunicationDevice, error) {
+	type CommunicationDevicesResponse struct {
+		Data []CommunicationDevice `json:"data"`
+		Meta struct {
+			CurrentPage int `json:"current_page"`
+			LastPage    int `json:"last_page"`
+		} `json:"meta"`
+	}
+
+	devices := make(map[string]CommunicationDevice)
+	for page := 1; ; page++ {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/communication-device?page=%d", geCloudV1BaseURL, page), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		cdr := CommunicationDevicesResponse{}
+		err = json.Unmarshal(body, &cdr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, device := range cdr.Data {
+			devices[device.Inverter.Serial] = device
+		}
+		if cdr.Meta.CurrentPage >= cdr.Meta.LastPage {
+			break
+		}
+	}
+
+	return devices, nil
+}
+
+// discoverFloat reads a numeric setting, returning 0 when it can't be read
+func (c *Client) discoverFloat(serial, name string, fallback int) float64 {
+	value, err := c.discoverSetting(serial, name, fallback)
+	if err != nil {
+		println(fmt.Errorf("error discovering %s for %s: %w", name, serial, err).Error())
+		return 0
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return f
+		}
+	}
+
+	println(fmt.Sprintf("error discovering %s for %s: unexpected value %v", name, serial, value))
+	return 0
+}
+
+// discoverChargeWindow reads the first AC charge slot, returning an empty window when it's unset or can't be read
+func (c *Client) discoverChargeWindow(serial string) string {
+	var times []string
+	for _, setting := range []struct {
+		name     string
+		fallback int
+	}{
+		{name: "AC Charge 1 Start Time", fallback: ACCharge1StartTimeSettingID},
+		{name: "AC Charge 1 End Time", fallback: ACCharge1EndTimeSettingID},
+	} {
+		value, err := c.discoverSetting(serial, setting.name, setting.fallback)
+		if err != nil {
+			println(fmt.Errorf("error discovering %s for %s: %w", setting.name, serial, err).Error())
+			return ""
+		}
+
+		t, ok := value.(string)
+		if _, err := time.Parse(slotFormat, t); !ok || err != nil {
+			println(fmt.Sprintf("error discovering %s for %s: unexpected value %v", setting.name, serial, value))
+			return ""
+		}
+		times = append(times, t)
+	}
+
+	if times[0] == times[1] {
+		return ""
+	}
+
+	return strings.Join(times, "-")
+}
+
+func (c *Client) discoverSetting(serial, name string, fallback int) (interface{}, error) {
+	id, _, err := c.settingID(serial, name, fallback)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readSetting(serial, id)
+}
+
+func (c *Client) writeDiscoveryCache(d *Discovery) error {
+	f, err := os.Create(path.Join(c.cacheDir, discoveryCacheFile))
+	if err != nil {
+		return fmt.Errorf("error creating discovery cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(d)
+	if err != nil {
+		return fmt.Errorf("error encoding discovery cache file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) readDiscoveryCache() (*Discovery, error) {
+	f, err := os.Open(path.Join(c.cacheDir, discoveryCacheFile))
+	if err != nil {
+		return nil, fmt.Errorf("error opening discovery cache file: %w", err)
+	}
+	defer f.Close()
+
+	d := &Discovery{}
+	err = gob.NewDecoder(f).Decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding discovery cache file: %w", err)
+	}
+
+	return d, nil
+}
+
+// ChargeSlotWritten records the first charge slot written to the inverter, so discovery can tell it apart from a
+// charge window set by the user
+func (c *Client) ChargeSlotWritten(slot Slot) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.writtenWindow = fmt.Sprintf("%s-%s", slot.Start.Local().Format(slotFormat), slot.End.Local().Format(slotFormat))
+	if c.cacheDir == "" {
+		return
+	}
+
+	f, err := os.Create(path.Join(c.cacheDir, writtenChargeSlotFile))
+	if err != nil {
+		println(fmt.Errorf("error creating written charge slot file: %w", err).Error())
+		return
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(c.writtenWindow)
+	if err != nil {
+		println(fmt.Errorf("error encoding written charge slot file: %w", err).Error())
+	}
+}
+
+// writtenChargeWindow returns the first charge slot last written as a window, empty when none has been written
+func (c *Client) writtenChargeWindow() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.writtenWindow != "" || c.cacheDir == "" {
+		return c.writtenWindow
+	}
+
+	f, err := os.Open(path.Join(c.cacheDir, writtenChargeSlotFile))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	_ = gob.NewDecoder(f).Decode(&c.writtenWindow)
+	return c.writtenWindow
+}
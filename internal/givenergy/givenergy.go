@@ -3,10 +3,12 @@ package givenergy
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
+	"sync"
 	"time"
 )
 
@@ -15,16 +17,31 @@ const (
 	ACUpperChargeLimitSettingID       = 77
 	ACUpperChargeLimitEnableSettingID = 17
 	EMSChargeSlot1SOCLimit            = 395
+	ACCharge1StartTimeSettingID       = 64
+	ACCharge1EndTimeSettingID         = 65
+	ACChargeEnableSettingID           = 66
+	DCDischarge1StartTimeSettingID    = 53
+	DCDischarge1EndTimeSettingID      = 54
+	DCDischargeEnableSettingID        = 56
+	EcoModeEnableSettingID            = 24
 )
 
 type Client struct {
-	c       *http.Client
-	serials []string
-	apiKey  string
-	ems     bool
+	m               sync.Mutex
+	c               *http.Client
+	serials         []string
+	apiKey          string
+	ems             bool
+	cacheDir        string
+	consumptionDays int
+	averages        *ConsumptionAverages
+	settings        map[string][]Setting
+	verifications   map[string]Verification
+	discovery       *Discovery
+	writtenWindow   string // first charge slot written, see ChargeSlotWritten
 }
 
-func NewClient(serials []string, apiKey string, ems bool) *Client {
+func NewClient(serials []string, apiKey string, ems bool, cacheDir string, consumptionDays int) *Client {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		panic(err)
@@ -34,10 +51,13 @@ func NewClient(serials []string, apiKey string, ems bool) *Client {
 	}
 
 	return &Client{
-		c:       &client,
-		serials: serials,
-		apiKey:  apiKey,
-		ems:     ems,
+		c:               &client,
+		serials:         serials,
+		apiKey:          apiKey,
+		ems:             ems,
+		cacheDir:        cacheDir,
+		consumptionDays: consumptionDays,
+		settings:        make(map[string][]Setting),
 	}
 }
 
@@ -76,10 +96,19 @@ type SystemData struct {
 	} `json:"data"`
 }
 
+// StatusError is returned when the cloud responds with an unexpected status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response code %d", e.StatusCode)
+}
+
 func (c *Client) GetLatestSystemData() (map[string]SystemData, error) {
 	sds := make(map[string]SystemData)
 	for _, serial := range c.serials {
-		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/inverter/%s/meter-data/latest", geCloudV1BaseURL, serial), nil)
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/inverter/%s/system-data/latest", geCloudV1BaseURL, serial), nil)
 		if err != nil {
 			return nil, err
 		}
@@ -90,7 +119,8 @@ func (c *Client) GetLatestSystemData() (map[string]SystemData, error) {
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("unexpected response code %d", resp.StatusCode)
+			resp.Body.Close()
+			return nil, &StatusError{StatusCode: resp.StatusCode}
 		}
 
 		body, err := io.ReadAll(resp.Body)
@@ -108,38 +138,52 @@ func (c *Client) GetLatestSystemData() (map[string]SystemData, error) {
 		sds[serial] = sd
 	}
 
-	return nil, nil
+	return sds, nil
+}
+
+// GetSOC returns the live battery state of charge averaged across inverters
+func (c *Client) GetSOC() (float64, error) {
+	sds, err := c.GetLatestSystemData()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(sds) == 0 {
+		return 0, errors.New("no system data available")
+	}
+
+	var soc float64
+	for _, sd := range sds {
+		soc = soc + float64(sd.Data.Battery.Percent)
+	}
+
+	return soc / float64(len(sds)), nil
 }
 
 func (c *Client) SetChargeUpperLimit(limit int) error {
 	for _, serial := range c.serials {
-		if c.ems {
-			err := c.sendModifySettingRequest(serial, EMSChargeSlot1SOCLimit, limit)
-			if err != nil {
-				return err
-			}
-		} else {
-			if limit == 100 {
-				err := c.sendModifySettingRequest(serial, ACUpperChargeLimitEnableSettingID, false)
-				if err != nil {
-					return err
-				}
-			} else {
-				err := c.sendModifySettingRequest(serial, ACUpperChargeLimitEnableSettingID, true)
-				if err != nil {
-					return err
-				}
-			}
-
-			err := c.sendModifySettingRequest(serial, ACUpperChargeLimitSettingID, limit)
-			if err != nil {
-				return err
-			}
+		err := c.setChargeUpperLimit(serial, limit)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func (c *Client) setChargeUpperLimit(serial string, limit int) error {
+	if c.ems {
+		return c.writeVerified(serial, EMSChargeSlot1SOCLimit, limit)
+	}
+
+	// the limit is only applied while it's enabled so the flag is verified as well
+	err := c.writeVerified(serial, ACUpperChargeLimitEnableSettingID, limit != 100)
+	if err != nil {
+		return err
+	}
+
+	return c.writeVerified(serial, ACUpperChargeLimitSettingID, limit)
+}
+
 func (c *Client) sendModifySettingRequest(serial string, id int, value interface{}) error {
 	type ModifySettingRequest struct {
 		Value interface{} `json:"value"`
@@ -172,7 +216,7 @@ func (c *Client) sendModifySettingRequest(serial string, id int, value interface
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("unexpected response code %d", resp.StatusCode)
+		return &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	body, err := io.ReadAll(resp.Body)
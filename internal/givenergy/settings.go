@@ -0,0 +1,323 @@
+package givenergy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type Setting struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Validation string `json:"validation"`
+}
+
+// Slot is a timed charge or discharge slot with the SOC to stop at
+type Slot struct {
+	Start     time.Time
+	End       time.Time
+	TargetSOC int
+}
+
+// slotSettings names the settings for a kind of slot, %d is replaced by the slot number
+type slotSettings struct {
+	kind          string
+	start         string
+	end           string
+	soc           string
+	startFallback int // setting id of the first slot's start time when it isn't listed by name
+	endFallback   int
+	verifySOC     bool // read back the soc limits after writing them
+}
+
+// GetSettings lists the settings the inverter supports, the list is cached as it doesn't change
+func (c *Client) GetSettings(serial string) ([]Setting, error) {
+	c.m.Lock()
+	settings, ok := c.settings[serial]
+	c.m.Unlock()
+	if ok {
+		return settings, nil
+	}
+
+	type SettingsResponse struct {
+		Data []Setting `json:"data"`
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/inverter/%s/settings", geCloudV1BaseURL, serial), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	sr := SettingsResponse{}
+	err = json.Unmarshal(body, &sr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	c.settings[serial] = sr.Data
+	c.m.Unlock()
+
+	return sr.Data, nil
+}
+
+// settingID looks up a setting by name, falling back to the given id when it isn't listed
+func (c *Client) settingID(serial, name string, fallback int) (int, bool, error) {
+	settings, err := c.GetSettings(serial)
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, setting := range settings {
+		if setting.Name == name {
+			return setting.ID, true, nil
+		}
+	}
+
+	return fallback, fallback != 0, nil
+}
+
+// SetChargeSlots writes the AC charge slot times and SOC limits, clearing any further slots the inverter supports
+func (c *Client) SetChargeSlots(slots []Slot) error {
+	for _, serial := range c.serials {
+		err := c.setChargeSlots(serial, slots)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) setChargeSlots(serial string, slots []Slot) error {
+	ss := c.chargeSlotSettings()
+	err := c.writeSlots(serial, slots, ss, func(i int, slot Slot) error {
+		// older firmware only has the single upper limit shared by all slots
+		if i == 1 {
+			return c.setChargeUpperLimit(serial, slot.TargetSOC)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !c.ems {
+		return c.sendModifySettingRequest(serial, ACChargeEnableSettingID, true)
+	}
+
+	return nil
+}
+
+func (c *Client) chargeSlotSettings() slotSettings {
+	if c.ems {
+		return slotSettings{
+			kind:      "charge",
+			start:     "Charge Slot %d Start Time",
+			end:       "Charge Slot %d End Time",
+			soc:       "Charge Slot %d SOC Limit",
+			verifySOC: true,
+		}
+	}
+
+	return slotSettings{
+		kind:          "charge",
+		start:         "AC Charge %d Start Time",
+		end:           "AC Charge %d End Time",
+		soc:           "AC Charge %d Upper SOC %% Limit",
+		startFallback: ACCharge1StartTimeSettingID,
+		endFallback:   ACCharge1EndTimeSettingID,
+		verifySOC:     true,
+	}
+}
+
+// ChargeSlotCount returns how many charge slots every inverter supports
+func (c *Client) ChargeSlotCount() (int, error) {
+	ss := c.chargeSlotSettings()
+	count := 0
+	for i, serial := range c.serials {
+		n := 0
+		for ; ; n++ {
+			fallback := 0
+			if n == 0 {
+				fallback = ss.startFallback
+			}
+
+			_, ok, err := c.settingID(serial, fmt.Sprintf(ss.start, n+1), fallback)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				break
+			}
+		}
+
+		if i == 0 || n < count {
+			count = n
+		}
+	}
+
+	return count, nil
+}
+
+// SharedChargeTarget reports whether any inverter's charge slots share its single upper limit, older firmware has no
+// per slot limits
+func (c *Client) SharedChargeTarget() (bool, error) {
+	ss := c.chargeSlotSettings()
+	for _, serial := range c.serials {
+		_, ok, err := c.settingID(serial, fmt.Sprintf(ss.soc, 1), 0)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// SetDischargeSlots writes the timed export slot times and lower SOC limits, clearing any further slots the inverter supports.
+// The slots only apply while the battery is in timed export mode, see SetBatteryMode.
+func (c *Client) SetDischargeSlots(slots []Slot) error {
+	for _, serial := range c.serials {
+		err := c.setDischargeSlots(serial, slots)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) setDischargeSlots(serial string, slots []Slot) error {
+	ss := slotSettings{
+		kind:          "discharge",
+		start:         "DC Discharge %d Start Time",
+		end:           "DC Discharge %d End Time",
+		soc:           "DC Discharge %d Lower SOC %% Limit",
+		startFallback: DCDischarge1StartTimeSettingID,
+		endFallback:   DCDischarge1EndTimeSettingID,
+	}
+	if c.ems {
+		ss = slotSettings{
+			kind:  "discharge",
+			start: "Export Slot %d Start Time",
+			end:   "Export Slot %d End Time",
+			soc:   "Export Slot %d SOC Limit",
+		}
+	}
+
+	err := c.writeSlots(serial, slots, ss, func(int, Slot) error {
+		// without a per slot limit the inverter discharges down to the battery reserve
+		return nil
+	})
+	return err
+}
+
+// ErrEMS is returned for settings the EMS doesn't have
+var ErrEMS = errors.New("setting not supported by the ems")
+
+// SetBatteryMode sets whether the battery only discharges to match demand (eco) and whether the discharge slots are
+// enabled, eco with discharge slots is timed demand and without eco it's timed export
+func (c *Client) SetBatteryMode(eco, discharge bool) error {
+	if c.ems {
+		return ErrEMS
+	}
+
+	for _, serial := range c.serials {
+		err := c.sendModifySettingRequest(serial, DCDischargeEnableSettingID, discharge)
+		if err != nil {
+			return err
+		}
+
+		err = c.sendModifySettingRequest(serial, EcoModeEnableSettingID, eco)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSlots writes the times and SOC limits of slots, clearing any further slots the inverter supports.
+// noSOC is called for slots without a SOC limit setting.
+func (c *Client) writeSlots(serial string, slots []Slot, ss slotSettings, noSOC func(i int, slot Slot) error) error {
+	for i := 1; ; i++ {
+		startFallback, endFallback := 0, 0
+		if i == 1 {
+			startFallback, endFallback = ss.startFallback, ss.endFallback
+		}
+
+		startID, ok, err := c.settingID(serial, fmt.Sprintf(ss.start, i), startFallback)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if i <= len(slots) {
+				return fmt.Errorf("inverter %s supports %d %s slots, %d requested", serial, i-1, ss.kind, len(slots))
+			}
+			break
+		}
+
+		endID, ok, err := c.settingID(serial, fmt.Sprintf(ss.end, i), endFallback)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("inverter %s has no end time setting for %s slot %d", serial, ss.kind, i)
+		}
+
+		start, end := "00:00", "00:00"
+		if i <= len(slots) {
+			start, end = slots[i-1].Start.Local().Format("15:04"), slots[i-1].End.Local().Format("15:04")
+		}
+
+		err = c.sendModifySettingRequest(serial, startID, start)
+		if err != nil {
+			return err
+		}
+
+		err = c.sendModifySettingRequest(serial, endID, end)
+		if err != nil {
+			return err
+		}
+
+		if i > len(slots) {
+			continue
+		}
+
+		socID, ok, err := c.settingID(serial, fmt.Sprintf(ss.soc, i), 0)
+		if err != nil {
+			return err
+		}
+		if ok && ss.verifySOC {
+			err = c.writeVerified(serial, socID, slots[i-1].TargetSOC)
+		} else if ok {
+			err = c.sendModifySettingRequest(serial, socID, slots[i-1].TargetSOC)
+		} else {
+			err = noSOC(i, slots[i-1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package givenergy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+const (
+	verificationsCacheFile = "chargeLimitVerifications.gob"
+
+	// verifyAttempts is how many times a charge limit is written before it's left unverified
+	verifyAttempts = 3
+	// verifyDelay gives the inverter time to apply a write before it's read back
+	verifyDelay = 5 * time.Second
+)
+
+// ErrUnverified is returned when a setting doesn't read back as written, the inverter may not have applied it
+var ErrUnverified = errors.New("setting unverified")
+
+// Verification is the outcome of reading back a charge limit after writing it
+type Verification struct {
+	Serial    string    `json:"serial"`
+	SettingID int       `json:"setting_id"`
+	Value     string    `json:"value"`
+	ReadValue string    `json:"read_value"` // empty when it couldn't be read
+	Attempts  int       `json:"attempts"`
+	Verified  bool      `json:"verified"`
+	At        time.Time `json:"at"`
+}
+
+// writeVerified writes a setting and reads it back, rewriting it when the inverter reports success but doesn't
+// apply it. The outcome is recorded, ErrUnverified is returned when it never reads back as written.
+func (c *Client) writeVerified(serial string, id int, value interface{}) error {
+	err := c.sendModifySettingRequest(serial, id, value)
+	if err != nil {
+		return err
+	}
+
+	v := Verification{
+		Serial:    serial,
+		SettingID: id,
+		Value:     fmt.Sprint(value),
+	}
+	for {
+		v.Attempts++
+		time.Sleep(verifyDelay)
+
+		read, err := c.readSetting(serial, id)
+		if err != nil {
+			println(fmt.Errorf("error reading back setting %d for %s: %w", id, serial, err).Error())
+			v.ReadValue = ""
+		} else {
+			v.ReadValue = fmt.Sprint(read)
+		}
+		v.Verified = v.ReadValue == v.Value
+
+		if v.Verified || v.Attempts == verifyAttempts {
+			break
+		}
+
+		println(fmt.Sprintf("setting %d for %s read back as %q after writing %s, rewriting", id, serial, v.ReadValue, v.Value))
+		err = c.sendModifySettingRequest(serial, id, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	v.At = time.Now().UTC()
+	c.recordVerification(v)
+
+	if !v.Verified {
+		return fmt.Errorf("%w: setting %d for %s read back as %q after %d attempts writing %s", ErrUnverified, id, serial, v.ReadValue, v.Attempts, v.Value)
+	}
+
+	return nil
+}
+
+// readSetting asks the inverter for the current value of a setting
+func (c *Client) readSetting(serial string, id int) (interface{}, error) {
+	type ReadSettingResponse struct {
+		Data struct {
+			Value interface{} `json:"value"`
+		} `json:"data"`
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/inverter/%s/settings/%d/read", geCloudV1BaseURL, serial, id), bytes.NewReader([]byte("{}")))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	rsResp := ReadSettingResponse{}
+	err = json.Unmarshal(body, &rsResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return rsResp.Data.Value, nil
+}
+
+func (c *Client) recordVerification(v Verification) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.loadVerifications()
+	c.verifications[fmt.Sprintf("%s/%d", v.Serial, v.SettingID)] = v
+	if c.cacheDir != "" {
+		err := c.writeVerificationsCache()
+		if err != nil {
+			println(fmt.Errorf("error updating charge limit verifications cache: %w", err).Error())
+		}
+	}
+}
+
+// GetVerifications returns the latest verification of each charge limit written
+func (c *Client) GetVerifications() []Verification {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.loadVerifications()
+	verifications := []Verification{}
+	for _, v := range c.verifications {
+		verifications = append(verifications, v)
+	}
+	sort.Slice(verifications, func(i, j int) bool {
+		if verifications[i].Serial != verifications[j].Serial {
+			return verifications[i].Serial < verifications[j].Serial
+		}
+		return verifications[i].SettingID < verifications[j].SettingID
+	})
+
+	return verifications
+}
+
+// loadVerifications restores the verifications from the cache the first time they're needed, the lock must be held
+func (c *Client) loadVerifications() {
+	if c.verifications != nil {
+		return
+	}
+
+	c.verifications = make(map[string]Verification)
+	if c.cacheDir == "" {
+		return
+	}
+
+	f, err := os.Open(path.Join(c.cacheDir, verificationsCacheFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		println(fmt.Errorf("error opening charge limit verifications cache file: %w", err).Error())
+		return
+	}
+	defer f.Close()
+
+	err = gob.NewDecoder(f).Decode(&c.verifications)
+	if err != nil {
+		println(fmt.Errorf("error decoding charge limit verifications cache file: %w", err).Error())
+	}
+}
+
+func (c *Client) writeVerificationsCache() error {
+	f, err := os.Create(path.Join(c.cacheDir, verificationsCacheFile))
+	if err != nil {
+		return fmt.Errorf("error creating charge limit verifications cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(c.verifications)
+	if err != nil {
+		return fmt.Errorf("error encoding charge limit verifications cache file: %w", err)
+	}
+
+	return nil
+}
@@ -3,9 +3,10 @@ package givtcp
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Client struct {
@@ -13,11 +14,26 @@ type Client struct {
 	baseURL string
 }
 
-func NewClient() *Client {
-	return &Client{
-		c:       http.DefaultClient,
-		baseURL: "http://givtcp:80", // todo make config
+type Option func(c *Client)
+
+// WithBaseURL sets the url of the givtcp rest api, http://givtcp:80 by default
+func WithBaseURL(url string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		c:       &http.Client{Timeout: 30 * time.Second},
+		baseURL: "http://givtcp:80",
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
 }
 
 func (c *Client) SetChargeTarget(target int) error {
@@ -25,29 +41,223 @@ func (c *Client) SetChargeTarget(target int) error {
 		ChargeToPercent int `json:"chargeToPercent"`
 	}
 
-	req := SetChargeTargetRequest{ChargeToPercent: target}
-	body, err := json.Marshal(req)
+	return c.post("setChargeTarget", SetChargeTargetRequest{ChargeToPercent: target})
+}
+
+// Data is the live inverter data givtcp publishes, powers are in W
+type Data struct {
+	Power struct {
+		Power Power `json:"Power"`
+	} `json:"Power"`
+	Control Control `json:"Control"`
+}
+
+type Power struct {
+	SOC            float64 `json:"SOC"`
+	PVPower        float64 `json:"PV_Power"`
+	LoadPower      float64 `json:"Load_Power"`
+	GridPower      float64 `json:"Grid_Power"`
+	ImportPower    float64 `json:"Import_Power"`
+	ExportPower    float64 `json:"Export_Power"`
+	BatteryPower   float64 `json:"Battery_Power"`
+	ChargePower    float64 `json:"Charge_Power"`
+	DischargePower float64 `json:"Discharge_Power"`
+}
+
+type Control struct {
+	Mode                    Mode    `json:"Mode"`
+	TargetSOC               float64 `json:"Target_SOC"`
+	BatteryPowerReserve     float64 `json:"Battery_Power_Reserve"`
+	BatteryChargeRate       float64 `json:"Battery_Charge_Rate"`
+	BatteryDischargeRate    float64 `json:"Battery_Discharge_Rate"`
+	EnableChargeSchedule    string  `json:"Enable_Charge_Schedule"`
+	EnableDischargeSchedule string  `json:"Enable_Discharge_Schedule"`
+}
+
+// GetData reads the live inverter data
+func (c *Client) GetData() (*Data, error) {
+	resp, err := c.c.Get(fmt.Sprintf("%s/readData", c.baseURL))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Endpoint: "readData", StatusCode: resp.StatusCode}
+	}
+
+	var data Data
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding givtcp data: %w", err)
+	}
+
+	return &data, nil
+}
+
+// GetSOC returns the live battery state of charge
+func (c *Client) GetSOC() (float64, error) {
+	data, err := c.GetData()
+	if err != nil {
+		return 0, err
+	}
+
+	return data.Power.Power.SOC, nil
+}
+
+// SetChargeSlot sets the times of AC charge slot n and the SOC it charges to, times are in local time
+func (c *Client) SetChargeSlot(n int, start, end time.Time, targetSOC int) error {
+	type SetChargeSlotRequest struct {
+		Start           string `json:"start"`
+		Finish          string `json:"finish"`
+		ChargeToPercent int    `json:"chargeToPercent"`
+	}
+
+	return c.post(fmt.Sprintf("setChargeSlot%d", n), SetChargeSlotRequest{
+		Start:           start.Local().Format("15:04"),
+		Finish:          end.Local().Format("15:04"),
+		ChargeToPercent: targetSOC,
+	})
+}
+
+// SetDischargeSlot sets the times of discharge slot n and the SOC it discharges down to, times are in local time
+func (c *Client) SetDischargeSlot(n int, start, end time.Time, targetSOC int) error {
+	type SetDischargeSlotRequest struct {
+		Start              string `json:"start"`
+		Finish             string `json:"finish"`
+		DischargeToPercent int    `json:"dischargeToPercent"`
+	}
+
+	return c.post(fmt.Sprintf("setDischargeSlot%d", n), SetDischargeSlotRequest{
+		Start:              start.Local().Format("15:04"),
+		Finish:             end.Local().Format("15:04"),
+		DischargeToPercent: targetSOC,
+	})
+}
+
+// EnableACCharge turns charging from the grid during the AC charge slots on or off
+func (c *Client) EnableACCharge(enabled bool) error {
+	return c.post("enableChargeSchedule", newStateRequest(enabled))
+}
+
+// EnableChargeTarget turns the charge target on or off, without it AC charge carries on to 100%
+func (c *Client) EnableChargeTarget(enabled bool) error {
+	return c.post("enableChargeTarget", newStateRequest(enabled))
+}
+
+// EnableDischarge turns the discharge slots on or off
+func (c *Client) EnableDischarge(enabled bool) error {
+	return c.post("enableDischarge", newStateRequest(enabled))
+}
+
+// SetBatteryReserve sets the SOC the battery stops discharging at
+func (c *Client) SetBatteryReserve(percent int) error {
+	type SetBatteryReserveRequest struct {
+		ReservePercent int `json:"reservePercent"`
+	}
+
+	return c.post("setBatteryReserve", SetBatteryReserveRequest{ReservePercent: percent})
+}
+
+// SetChargeRate limits the battery charge power in W
+func (c *Client) SetChargeRate(watts int) error {
+	type SetChargeRateRequest struct {
+		ChargeRate int `json:"chargeRate"`
+	}
+
+	return c.post("setChargeRate", SetChargeRateRequest{ChargeRate: watts})
+}
+
+// SetDischargeRate limits the battery discharge power in W
+func (c *Client) SetDischargeRate(watts int) error {
+	type SetDischargeRateRequest struct {
+		DischargeRate int `json:"dischargeRate"`
+	}
+
+	return c.post("setDischargeRate", SetDischargeRateRequest{DischargeRate: watts})
+}
+
+// Mode is a battery mode by its givtcp name
+type Mode string
+
+const (
+	ModeEco         Mode = "Eco"
+	ModeEcoPaused   Mode = "Eco (Paused)"
+	ModeTimedDemand Mode = "Timed Demand"
+	ModeTimedExport Mode = "Timed Export"
+)
+
+// SetBatteryMode sets whether the battery discharges to match demand all the time (eco) or only during the discharge
+// slots, either matching demand or at full power exporting the excess
+func (c *Client) SetBatteryMode(mode Mode) error {
+	type SetBatteryModeRequest struct {
+		Mode Mode `json:"mode"`
 	}
 
-	type SetChargeTargetResponse struct {
-		Result string `json:"result"`
+	return c.post("setBatteryMode", SetBatteryModeRequest{Mode: mode})
+}
+
+type stateRequest struct {
+	State string `json:"state"`
+}
+
+func newStateRequest(enabled bool) stateRequest {
+	if enabled {
+		return stateRequest{State: "enable"}
+	}
+	return stateRequest{State: "disable"}
+}
+
+// Error is returned when givtcp responds with an error status or a result other than success
+type Error struct {
+	Endpoint   string
+	StatusCode int
+	Result     string
+}
+
+func (e *Error) Error() string {
+	if e.Result == "" {
+		return fmt.Sprintf("unexpected response code %d calling givtcp %s", e.StatusCode, e.Endpoint)
 	}
+	return fmt.Sprintf("error calling givtcp %s: %s", e.Endpoint, e.Result)
+}
 
-	resp, err := c.c.Post(fmt.Sprintf("%s/setChargeTarget", c.baseURL), "application/json", bytes.NewReader(body))
+// ControlResponse is givtcp's response to a control request, the outcome is reported as a sentence
+type ControlResponse struct {
+	Result string `json:"result"`
+}
+
+func (cr ControlResponse) Success() bool {
+	return strings.HasSuffix(cr.Result, "was a success")
+}
+
+// post sends req to a givtcp control endpoint
+func (c *Client) post(endpoint string, req interface{}) error {
+	body, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
-	var setChargeTargetResponse SetChargeTargetResponse
-	err = json.NewDecoder(resp.Body).Decode(&setChargeTargetResponse)
+	resp, err := c.c.Post(fmt.Sprintf("%s/%s", c.baseURL, endpoint), "application/json", bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
+	// givtcp describes most failures in the result, so it's read for error statuses too
+	var controlResponse ControlResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&controlResponse)
+
+	if resp.StatusCode != http.StatusOK {
+		return &Error{Endpoint: endpoint, StatusCode: resp.StatusCode, Result: controlResponse.Result}
+	}
+
+	if decodeErr != nil {
+		return fmt.Errorf("error decoding givtcp %s response: %w", endpoint, decodeErr)
+	}
 
-	if setChargeTargetResponse.Result != "Setting Charge Target was a success" {
-		return errors.New("error setting charge target via givtcp")
+	if !controlResponse.Success() {
+		return &Error{Endpoint: endpoint, StatusCode: resp.StatusCode, Result: controlResponse.Result}
 	}
 
 	return nil
@@ -0,0 +1,74 @@
+package inverter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+)
+
+// Cloud controls the inverter through the givenergy cloud api
+type Cloud struct {
+	gec *givenergy.Client
+}
+
+func NewCloud(gec *givenergy.Client) *Cloud {
+	return &Cloud{
+		gec: gec,
+	}
+}
+
+func (c *Cloud) SetChargeTarget(soc int) error {
+	return c.gec.SetChargeUpperLimit(soc)
+}
+
+func (c *Cloud) GetSOC() (float64, error) {
+	return c.gec.GetSOC()
+}
+
+func (c *Cloud) ChargeSlotCount() (int, error) {
+	return c.gec.ChargeSlotCount()
+}
+
+func (c *Cloud) SharedChargeTarget() (bool, error) {
+	return c.gec.SharedChargeTarget()
+}
+
+func (c *Cloud) SetChargeSlots(slots []Slot) error {
+	return c.gec.SetChargeSlots(cloudSlots(slots))
+}
+
+func (c *Cloud) SetDischargeSlots(slots []Slot) error {
+	return c.gec.SetDischargeSlots(cloudSlots(slots))
+}
+
+func (c *Cloud) SetBatteryMode(mode Mode) error {
+	var err error
+	switch mode {
+	case ModeEco:
+		err = c.gec.SetBatteryMode(true, false)
+	case ModeTimedDemand:
+		err = c.gec.SetBatteryMode(true, true)
+	case ModeTimedExport:
+		err = c.gec.SetBatteryMode(false, true)
+	default:
+		return fmt.Errorf("unknown battery mode %s", mode)
+	}
+	if errors.Is(err, givenergy.ErrEMS) {
+		return fmt.Errorf("%w: %s", ErrNotSupported, err)
+	}
+
+	return err
+}
+
+func cloudSlots(slots []Slot) []givenergy.Slot {
+	var gecSlots []givenergy.Slot
+	for _, slot := range slots {
+		gecSlots = append(gecSlots, givenergy.Slot{
+			Start:     slot.Start,
+			End:       slot.End,
+			TargetSOC: slot.TargetSOC,
+		})
+	}
+	return gecSlots
+}
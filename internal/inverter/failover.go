@@ -0,0 +1,123 @@
+package inverter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Failover uses the primary controller, falling back to the secondary when the primary can't be reached or doesn't
+// support a call. After the primary can't be reached it's skipped until retryAfter has passed so a down local api
+// doesn't slow every call. Rejections and validation errors from the primary are returned as is, the fallback would
+// only apply a change the primary refused.
+type Failover struct {
+	m          sync.Mutex
+	primary    Controller
+	fallback   Controller
+	retryAfter time.Duration
+	downUntil  time.Time
+}
+
+func NewFailover(primary, fallback Controller, retryAfter time.Duration) *Failover {
+	return &Failover{
+		primary:    primary,
+		fallback:   fallback,
+		retryAfter: retryAfter,
+	}
+}
+
+func (f *Failover) SetChargeTarget(soc int) error {
+	return f.do("setting charge target", func(c Controller) error {
+		return c.SetChargeTarget(soc)
+	})
+}
+
+func (f *Failover) GetSOC() (float64, error) {
+	var soc float64
+	err := f.do("getting soc", func(c Controller) error {
+		var err error
+		soc, err = c.GetSOC()
+		return err
+	})
+	return soc, err
+}
+
+// ChargeSlotCount returns the fewest slots either controller supports, as either may end up setting them
+func (f *Failover) ChargeSlotCount() (int, error) {
+	primary, err := f.primary.ChargeSlotCount()
+	if err != nil {
+		return 0, err
+	}
+
+	fallback, err := f.fallback.ChargeSlotCount()
+	if err != nil {
+		println(fmt.Errorf("err getting charge slot count from fallback inverter controller: %w", err).Error())
+		return primary, nil
+	}
+
+	if fallback < primary {
+		return fallback, nil
+	}
+	return primary, nil
+}
+
+// SharedChargeTarget reports whether either controller's slots share a target, as either may end up setting them
+func (f *Failover) SharedChargeTarget() (bool, error) {
+	primary, err := f.primary.SharedChargeTarget()
+	if err != nil {
+		return false, err
+	}
+
+	fallback, err := f.fallback.SharedChargeTarget()
+	if err != nil {
+		println(fmt.Errorf("err getting charge target sharing from fallback inverter controller: %w", err).Error())
+		return primary, nil
+	}
+
+	return primary || fallback, nil
+}
+
+func (f *Failover) SetChargeSlots(slots []Slot) error {
+	return f.do("setting charge slots", func(c Controller) error {
+		return c.SetChargeSlots(slots)
+	})
+}
+
+func (f *Failover) SetDischargeSlots(slots []Slot) error {
+	return f.do("setting discharge slots", func(c Controller) error {
+		return c.SetDischargeSlots(slots)
+	})
+}
+
+func (f *Failover) SetBatteryMode(mode Mode) error {
+	return f.do("setting battery mode", func(c Controller) error {
+		return c.SetBatteryMode(mode)
+	})
+}
+
+func (f *Failover) do(action string, fn func(c Controller) error) error {
+	f.m.Lock()
+	down := time.Now().Before(f.downUntil)
+	f.m.Unlock()
+
+	if !down {
+		err := fn(f.primary)
+		if err == nil {
+			return nil
+		}
+
+		switch {
+		case errors.Is(err, ErrNotSupported):
+		case Transient(err):
+			f.m.Lock()
+			f.downUntil = time.Now().Add(f.retryAfter)
+			f.m.Unlock()
+		default:
+			return err
+		}
+		println(fmt.Errorf("err %s with primary inverter controller, falling back: %w", action, err).Error())
+	}
+
+	return fn(f.fallback)
+}
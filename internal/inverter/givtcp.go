@@ -0,0 +1,86 @@
+package inverter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/givtcp"
+)
+
+// givTCPSlots is how many charge and discharge slots givtcp can set
+const givTCPSlots = 2
+
+// GivTCP controls the inverter through givtcp on the local network
+type GivTCP struct {
+	gtcpc *givtcp.Client
+}
+
+func NewGivTCP(gtcpc *givtcp.Client) *GivTCP {
+	return &GivTCP{
+		gtcpc: gtcpc,
+	}
+}
+
+func (g *GivTCP) SetChargeTarget(soc int) error {
+	return g.gtcpc.SetChargeTarget(soc)
+}
+
+func (g *GivTCP) GetSOC() (float64, error) {
+	return g.gtcpc.GetSOC()
+}
+
+func (g *GivTCP) ChargeSlotCount() (int, error) {
+	return givTCPSlots, nil
+}
+
+func (g *GivTCP) SharedChargeTarget() (bool, error) {
+	return false, nil
+}
+
+func (g *GivTCP) SetChargeSlots(slots []Slot) error {
+	err := g.writeSlots("charge", slots, g.gtcpc.SetChargeSlot)
+	if err != nil {
+		return err
+	}
+
+	return g.gtcpc.EnableACCharge(len(slots) > 0)
+}
+
+func (g *GivTCP) SetDischargeSlots(slots []Slot) error {
+	return g.writeSlots("discharge", slots, g.gtcpc.SetDischargeSlot)
+}
+
+func (g *GivTCP) SetBatteryMode(mode Mode) error {
+	switch mode {
+	case ModeEco:
+		return g.gtcpc.SetBatteryMode(givtcp.ModeEco)
+	case ModeTimedDemand:
+		return g.gtcpc.SetBatteryMode(givtcp.ModeTimedDemand)
+	case ModeTimedExport:
+		return g.gtcpc.SetBatteryMode(givtcp.ModeTimedExport)
+	default:
+		return fmt.Errorf("unknown battery mode %s", mode)
+	}
+}
+
+// writeSlots writes each slot, clearing the remaining slots with a zero length slot
+func (g *GivTCP) writeSlots(kind string, slots []Slot, set func(n int, start, end time.Time, targetSOC int) error) error {
+	if len(slots) > givTCPSlots {
+		return fmt.Errorf("givtcp supports %d %s slots, %d requested", givTCPSlots, kind, len(slots))
+	}
+
+	midnight := time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+	for i := 1; i <= givTCPSlots; i++ {
+		slot := Slot{Start: midnight, End: midnight, TargetSOC: 100}
+		if i <= len(slots) {
+			slot = slots[i-1]
+		}
+
+		err := set(i, slot.Start, slot.End, slot.TargetSOC)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
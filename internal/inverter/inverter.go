@@ -0,0 +1,78 @@
+// Package inverter controls the inverter through whichever api is available, the givenergy cloud or givtcp on the
+// local network
+package inverter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/givenergy"
+	"github.com/jakekeeys/givforecast/internal/givtcp"
+)
+
+// ErrNotSupported is returned by controllers that can't perform an operation
+var ErrNotSupported = errors.New("not supported by this inverter controller")
+
+// Mode is how the battery discharges
+type Mode string
+
+const (
+	// ModeEco discharges the battery to match demand whenever there's a shortfall
+	ModeEco Mode = "eco"
+	// ModeTimedDemand discharges the battery to match demand only during the discharge slots
+	ModeTimedDemand Mode = "timed_demand"
+	// ModeTimedExport discharges the battery at full power during the discharge slots, exporting the excess
+	ModeTimedExport Mode = "timed_export"
+)
+
+// Slot is a timed charge or discharge slot with the SOC to stop at
+type Slot struct {
+	Start     time.Time
+	End       time.Time
+	TargetSOC int
+}
+
+// Controller reads and sets the inverter's battery state
+type Controller interface {
+	// SetChargeTarget sets the SOC the AC charge stops at
+	SetChargeTarget(soc int) error
+	// GetSOC returns the live battery state of charge
+	GetSOC() (float64, error)
+	// ChargeSlotCount returns how many charge slots SetChargeSlots can set
+	ChargeSlotCount() (int, error)
+	// SharedChargeTarget reports whether the charge slots share a single target rather than each having their own
+	SharedChargeTarget() (bool, error)
+	// SetChargeSlots sets the AC charge slots, clearing any others
+	SetChargeSlots(slots []Slot) error
+	// SetDischargeSlots sets the timed export slots, clearing any others. The slots only apply in ModeTimedExport.
+	SetDischargeSlots(slots []Slot) error
+	// SetBatteryMode sets how the battery discharges
+	SetBatteryMode(mode Mode) error
+}
+
+// Transient reports whether err is worth retrying, i.e. the api couldn't be reached or had a server error. Rejected
+// and invalid requests fail the same way every time.
+func Transient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	var gtcpErr *givtcp.Error
+	if errors.As(err, &gtcpErr) {
+		return transientStatus(gtcpErr.StatusCode)
+	}
+
+	var geErr *givenergy.StatusError
+	if errors.As(err, &geErr) {
+		return transientStatus(geErr.StatusCode)
+	}
+
+	return false
+}
+
+func transientStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
@@ -0,0 +1,109 @@
+package inverter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/modbus"
+)
+
+// Modbus controls the inverter directly over its data adapter on the local network
+type Modbus struct {
+	mc *modbus.Client
+}
+
+func NewModbus(mc *modbus.Client) *Modbus {
+	return &Modbus{
+		mc: mc,
+	}
+}
+
+func (m *Modbus) SetChargeTarget(soc int) error {
+	return m.mc.SetChargeTarget(soc)
+}
+
+func (m *Modbus) GetSOC() (float64, error) {
+	return m.mc.GetSOC()
+}
+
+func (m *Modbus) ChargeSlotCount() (int, error) {
+	return len(modbus.ChargeSlots), nil
+}
+
+func (m *Modbus) SharedChargeTarget() (bool, error) {
+	return true, nil
+}
+
+// SetChargeSlots sets the charge slots, the inverter has a single charge target so they all charge to the highest
+// of the slots' targets
+func (m *Modbus) SetChargeSlots(slots []Slot) error {
+	err := m.writeSlots("charge", slots, modbus.ChargeSlots)
+	if err != nil {
+		return err
+	}
+
+	if len(slots) > 0 {
+		target := slots[0].TargetSOC
+		for _, slot := range slots[1:] {
+			if slot.TargetSOC > target {
+				target = slot.TargetSOC
+			}
+		}
+
+		err = m.mc.SetChargeTarget(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.mc.EnableCharge(len(slots) > 0)
+}
+
+// SetDischargeSlots sets the discharge slots, the inverter discharges down to the battery reserve as it has no per
+// slot limit
+func (m *Modbus) SetDischargeSlots(slots []Slot) error {
+	return m.writeSlots("discharge", slots, modbus.DischargeSlots)
+}
+
+func (m *Modbus) SetBatteryMode(mode Mode) error {
+	var eco, discharge bool
+	switch mode {
+	case ModeEco:
+		eco, discharge = true, false
+	case ModeTimedDemand:
+		eco, discharge = true, true
+	case ModeTimedExport:
+		eco, discharge = false, true
+	default:
+		return fmt.Errorf("unknown battery mode %s", mode)
+	}
+
+	err := m.mc.EnableDischarge(discharge)
+	if err != nil {
+		return err
+	}
+
+	return m.mc.SetEco(eco)
+}
+
+// writeSlots writes each slot, clearing the remaining slots with a zero length slot
+func (m *Modbus) writeSlots(kind string, slots []Slot, registers [][2]uint16) error {
+	if len(slots) > len(registers) {
+		return fmt.Errorf("inverter supports %d %s slots, %d requested", len(registers), kind, len(slots))
+	}
+
+	midnight := time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+	for i, r := range registers {
+		start, end := midnight, midnight
+		if i < len(slots) {
+			start, end = slots[i].Start, slots[i].End
+		}
+
+		err := m.mc.SetSlot(r, start, end)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package inverter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/modbus"
+)
+
+func startFake(t *testing.T) (*modbus.FakeServer, *Modbus) {
+	t.Helper()
+
+	s := modbus.NewFakeServer("SA1234G567")
+	addr, err := s.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s, NewModbus(modbus.NewClient(addr, modbus.WithTimeout(time.Second)))
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2023, 1, 2, hour, minute, 0, 0, time.Local)
+}
+
+func TestModbusSetChargeSlots(t *testing.T) {
+	s, m := startFake(t)
+	s.SetHoldingRegister(modbus.HRChargeSlot2Start, 1300)
+	s.SetHoldingRegister(modbus.HRChargeSlot2End, 1400)
+
+	err := m.SetChargeSlots([]Slot{{Start: at(0, 30), End: at(4, 30), TargetSOC: 80}})
+	if err != nil {
+		t.Fatalf("SetChargeSlots() error = %v", err)
+	}
+
+	want := map[uint16]uint16{
+		modbus.HRChargeSlot1Start:   30,
+		modbus.HRChargeSlot1End:     430,
+		modbus.HRChargeSlot2Start:   0, // unused slots are cleared
+		modbus.HRChargeSlot2End:     0,
+		modbus.HREnableChargeTarget: 1,
+		modbus.HRChargeTargetSOC:    80,
+		modbus.HREnableCharge:       1,
+	}
+	for register, value := range want {
+		if got := s.HoldingRegister(register); got != value {
+			t.Errorf("register %d = %d, want %d", register, got, value)
+		}
+	}
+}
+
+func TestModbusSetChargeSlotsDifferentTargets(t *testing.T) {
+	s, m := startFake(t)
+
+	err := m.SetChargeSlots([]Slot{
+		{Start: at(0, 30), End: at(4, 30), TargetSOC: 80},
+		{Start: at(13, 0), End: at(14, 0), TargetSOC: 90},
+	})
+	if err != nil {
+		t.Fatalf("SetChargeSlots() error = %v", err)
+	}
+
+	// the single target is the highest so neither slot undercharges
+	want := map[uint16]uint16{
+		modbus.HRChargeSlot2Start: 1300,
+		modbus.HRChargeSlot2End:   1400,
+		modbus.HRChargeTargetSOC:  90,
+	}
+	for register, value := range want {
+		if got := s.HoldingRegister(register); got != value {
+			t.Errorf("register %d = %d, want %d", register, got, value)
+		}
+	}
+}
+
+func TestModbusClearChargeSlots(t *testing.T) {
+	s, m := startFake(t)
+	s.SetHoldingRegister(modbus.HRChargeSlot1Start, 30)
+	s.SetHoldingRegister(modbus.HRChargeSlot1End, 430)
+	s.SetHoldingRegister(modbus.HREnableCharge, 1)
+
+	err := m.SetChargeSlots(nil)
+	if err != nil {
+		t.Fatalf("SetChargeSlots() error = %v", err)
+	}
+
+	for _, register := range []uint16{modbus.HRChargeSlot1Start, modbus.HRChargeSlot1End, modbus.HREnableCharge} {
+		if got := s.HoldingRegister(register); got != 0 {
+			t.Errorf("register %d = %d, want 0", register, got)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// fakeRegisters is how many of each kind of register the fake inverter has
+const fakeRegisters = 3 * MaxRegisters
+
+// FakeServer is an in memory inverter speaking the data adapter protocol, for running against without an inverter.
+// Reads outside its registers are answered with an exception as the real inverter does.
+type FakeServer struct {
+	m        sync.Mutex
+	serial   string
+	holding  [fakeRegisters]uint16
+	input    [fakeRegisters]uint16
+	l        net.Listener
+	requests int
+}
+
+func NewFakeServer(inverterSerial string) *FakeServer {
+	return &FakeServer{
+		serial: inverterSerial,
+	}
+}
+
+// Start listens on addr, e.g. 127.0.0.1:0, returning the address it's listening on
+func (s *FakeServer) Start(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	s.l = l
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return l.Addr().String(), nil
+}
+
+func (s *FakeServer) Close() error {
+	if s.l == nil {
+		return nil
+	}
+	return s.l.Close()
+}
+
+func (s *FakeServer) HoldingRegister(register uint16) uint16 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.holding[register]
+}
+
+func (s *FakeServer) SetHoldingRegister(register, value uint16) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.holding[register] = value
+}
+
+func (s *FakeServer) SetInputRegister(register, value uint16) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.input[register] = value
+}
+
+// Requests returns how many modbus requests have been answered
+func (s *FakeServer) Requests() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.requests
+}
+
+func (s *FakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// the real adapter sends heartbeats the client has to skip
+	_, err := conn.Write(encodeFrame(functionHeartbeat, serialBytes("FAKEADAPTR")))
+	if err != nil {
+		return
+	}
+
+	for {
+		function, body, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		if function != functionTransparent || len(body) < serialLen+paddingLen+6+2 {
+			continue
+		}
+
+		resp, err := s.handle(body[serialLen+paddingLen : len(body)-2])
+		if err != nil {
+			return
+		}
+
+		frame := append(append([]byte{}, body[:serialLen+paddingLen]...), resp...)
+		check := crc16(resp)
+		_, err = conn.Write(encodeFrame(functionTransparent, append(frame, byte(check>>8), byte(check))))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request pdu of slave address, function, register and count or value
+func (s *FakeServer) handle(pdu []byte) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.requests++
+
+	function := pdu[1]
+	register := binary.BigEndian.Uint16(pdu[2:])
+	value := binary.BigEndian.Uint16(pdu[4:])
+
+	resp := []byte{pdu[0], function}
+	resp = append(resp, serialBytes(s.serial)...)
+	resp = append(resp, pdu[2:6]...)
+
+	var registers []uint16
+	switch function {
+	case readHoldingRegisters:
+		registers = s.holding[:]
+	case readInputRegisters:
+		registers = s.input[:]
+	case writeHoldingRegister:
+		if int(register) >= len(s.holding) {
+			return exception(pdu), nil
+		}
+		s.holding[register] = value
+		return resp, nil
+	default:
+		return nil, errors.New("unsupported function")
+	}
+
+	if value == 0 || value > MaxRegisters || int(register)+int(value) > len(registers) {
+		return exception(pdu), nil
+	}
+	for _, r := range registers[register : register+value] {
+		resp = append(resp, byte(r>>8), byte(r))
+	}
+
+	return resp, nil
+}
+
+func exception(pdu []byte) []byte {
+	return []byte{pdu[0], pdu[1] | 0x80, 0x02}
+}
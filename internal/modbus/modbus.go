@@ -0,0 +1,270 @@
+// Package modbus talks to givenergy inverters directly over the local modbus tcp protocol of their wifi data adapter.
+// The adapter wraps each modbus request in a transparent frame addressed with the adapter's serial.
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPort = "8899"
+
+	transactionID = 0x5959
+	protocolID    = 0x0001
+	unitID        = 0x01
+
+	// frame functions, heartbeats are sent by the adapter and have to be ignored
+	functionHeartbeat   = 0x01
+	functionTransparent = 0x02
+
+	// modbus functions
+	readHoldingRegisters = 0x03
+	readInputRegisters   = 0x04
+	writeHoldingRegister = 0x06
+
+	slaveAddress = 0x32
+
+	// MaxRegisters is the most registers a single read returns
+	MaxRegisters = 60
+
+	headerLen  = 8
+	serialLen  = 10
+	paddingLen = 8
+)
+
+// ErrException is returned when the inverter rejects a request
+var ErrException = errors.New("inverter returned an exception")
+
+type Client struct {
+	m             sync.Mutex
+	addr          string
+	adapterSerial string
+	timeout       time.Duration
+}
+
+type Option func(c *Client)
+
+// WithAdapterSerial sets the serial the requests are addressed to, the adapter answers to any serial by default
+func WithAdapterSerial(serial string) Option {
+	return func(c *Client) {
+		c.adapterSerial = serial
+	}
+}
+
+// WithTimeout sets how long to wait for the inverter to respond, 10s by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// NewClient creates a client for the inverter at addr, the default port is used when addr doesn't have one
+func NewClient(addr string, opts ...Option) *Client {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+
+	c := &Client{
+		addr:          addr,
+		adapterSerial: "AB1234G567",
+		timeout:       10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ReadHoldingRegisters reads count holding registers from base
+func (c *Client) ReadHoldingRegisters(base, count uint16) ([]uint16, error) {
+	return c.read(readHoldingRegisters, base, count)
+}
+
+// ReadInputRegisters reads count input registers from base
+func (c *Client) ReadInputRegisters(base, count uint16) ([]uint16, error) {
+	return c.read(readInputRegisters, base, count)
+}
+
+// WriteHoldingRegister writes a single holding register
+func (c *Client) WriteHoldingRegister(register, value uint16) error {
+	resp, err := c.do(writeHoldingRegister, register, value)
+	if err != nil {
+		return err
+	}
+
+	// serial, register, value
+	if len(resp) < serialLen+4 {
+		return fmt.Errorf("short write response for register %d", register)
+	}
+
+	r := binary.BigEndian.Uint16(resp[serialLen:])
+	v := binary.BigEndian.Uint16(resp[serialLen+2:])
+	if r != register || v != value {
+		return fmt.Errorf("inverter echoed register %d value %d writing register %d value %d", r, v, register, value)
+	}
+
+	return nil
+}
+
+func (c *Client) read(function byte, base, count uint16) ([]uint16, error) {
+	if count == 0 || count > MaxRegisters {
+		return nil, fmt.Errorf("can read 1 to %d registers, %d requested", MaxRegisters, count)
+	}
+
+	resp, err := c.do(function, base, count)
+	if err != nil {
+		return nil, err
+	}
+
+	// serial, base, count, values
+	if len(resp) < serialLen+4 {
+		return nil, fmt.Errorf("short read response for registers %d-%d", base, base+count-1)
+	}
+
+	b := binary.BigEndian.Uint16(resp[serialLen:])
+	n := binary.BigEndian.Uint16(resp[serialLen+2:])
+	values := resp[serialLen+4:]
+	if b != base || n != count || len(values) < int(count)*2 {
+		return nil, fmt.Errorf("inverter returned %d registers from %d reading %d from %d", n, b, count, base)
+	}
+
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(values[i*2:])
+	}
+
+	return registers, nil
+}
+
+// do sends a modbus request over a new connection and returns the response payload following the function code,
+// without the check code
+func (c *Client) do(function byte, register, value uint16) ([]byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write(encodeRequest(c.adapterSerial, function, register, value))
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		frameFunction, body, err := readFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		if frameFunction != functionTransparent {
+			continue
+		}
+
+		// adapter serial, padding, slave address, function code, payload, check code
+		if len(body) < serialLen+paddingLen+2+2 {
+			return nil, errors.New("short response from inverter")
+		}
+		pdu := body[serialLen+paddingLen : len(body)-2]
+		if check := binary.BigEndian.Uint16(body[len(body)-2:]); check != crc16(pdu) {
+			return nil, fmt.Errorf("invalid check code %04x in response, expected %04x", check, crc16(pdu))
+		}
+
+		switch pdu[1] {
+		case function:
+			return pdu[2:], nil
+		case function | 0x80:
+			return nil, fmt.Errorf("%w for function %d register %d", ErrException, function, register)
+		}
+	}
+}
+
+// encodeRequest builds a transparent frame for a modbus request, the request is addressed by register and either a
+// count to read or a value to write
+func encodeRequest(adapterSerial string, function byte, register, value uint16) []byte {
+	pdu := []byte{slaveAddress, function, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(pdu[2:], register)
+	binary.BigEndian.PutUint16(pdu[4:], value)
+
+	body := serialBytes(adapterSerial)
+	body = append(body, 0, 0, 0, 0, 0, 0, 0, 8)
+	body = append(body, pdu...)
+	check := crc16(pdu)
+	body = append(body, byte(check>>8), byte(check))
+
+	return encodeFrame(functionTransparent, body)
+}
+
+func encodeFrame(function byte, body []byte) []byte {
+	frame := make([]byte, headerLen, headerLen+len(body))
+	binary.BigEndian.PutUint16(frame[0:], transactionID)
+	binary.BigEndian.PutUint16(frame[2:], protocolID)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(body)+2))
+	frame[6] = unitID
+	frame[7] = function
+	return append(frame, body...)
+}
+
+// readFrame reads a frame returning its function and body
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, headerLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if binary.BigEndian.Uint16(header[0:]) != transactionID || binary.BigEndian.Uint16(header[2:]) != protocolID {
+		return 0, nil, fmt.Errorf("unexpected frame header % x", header)
+	}
+
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 2 {
+		return 0, nil, fmt.Errorf("invalid frame length %d", length)
+	}
+
+	body := make([]byte, length-2)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[7], body, nil
+}
+
+// serialBytes pads or truncates a serial to its fixed width
+func serialBytes(serial string) []byte {
+	b := []byte(strings.Repeat("*", serialLen))
+	copy(b, serial)
+	return b
+}
+
+// crc16 is the modbus check code
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
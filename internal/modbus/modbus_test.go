@@ -0,0 +1,164 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want uint16
+	}{
+		{data: []byte("123456789"), want: 0x4b37},
+		{data: []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a}, want: 0xcdc5},
+	}
+
+	for _, tt := range tests {
+		if got := crc16(tt.data); got != tt.want {
+			t.Errorf("crc16(% x) = %04x, want %04x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeRequest(t *testing.T) {
+	frame := encodeRequest("WF1234G567", readHoldingRegisters, 60, 60)
+
+	want := []byte{
+		0x59, 0x59, 0x00, 0x01, 0x00, 0x1c, 0x01, 0x02, // header, length counts the uid and function
+		'W', 'F', '1', '2', '3', '4', 'G', '5', '6', '7', // adapter serial
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, // padding
+		0x32, 0x03, 0x00, 0x3c, 0x00, 0x3c, // slave address, function, base register, count
+	}
+	check := crc16(want[len(want)-6:])
+	want = append(want, byte(check>>8), byte(check))
+
+	if !bytes.Equal(frame, want) {
+		t.Errorf("encodeRequest() = % x, want % x", frame, want)
+	}
+
+	function, body, err := readFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("readFrame() error = %v", err)
+	}
+	if function != functionTransparent || !bytes.Equal(body, want[headerLen:]) {
+		t.Errorf("readFrame() = %d % x, want %d % x", function, body, functionTransparent, want[headerLen:])
+	}
+}
+
+func TestReadFrameInvalidHeader(t *testing.T) {
+	frame := encodeFrame(functionTransparent, []byte{1, 2, 3})
+	frame[0] = 0
+
+	_, _, err := readFrame(bytes.NewReader(frame))
+	if err == nil {
+		t.Error("readFrame() error = nil, want an error for the wrong transaction id")
+	}
+}
+
+func startFake(t *testing.T) (*FakeServer, *Client) {
+	t.Helper()
+
+	s := NewFakeServer("SA1234G567")
+	addr, err := s.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s, NewClient(addr, WithTimeout(time.Second))
+}
+
+// the fake sends a heartbeat on connect so every request also covers the client skipping it
+func TestReadHoldingRegisters(t *testing.T) {
+	s, c := startFake(t)
+	s.SetHoldingRegister(HRChargeTargetSOC, 80)
+	s.SetHoldingRegister(HREnableCharge, 1)
+
+	registers, err := c.ReadHoldingRegisters(60, MaxRegisters)
+	if err != nil {
+		t.Fatalf("ReadHoldingRegisters() error = %v", err)
+	}
+	if len(registers) != MaxRegisters {
+		t.Fatalf("ReadHoldingRegisters() returned %d registers, want %d", len(registers), MaxRegisters)
+	}
+	if registers[HRChargeTargetSOC-60] != 80 || registers[HREnableCharge-60] != 1 {
+		t.Errorf("ReadHoldingRegisters() = %v, want 80 at %d and 1 at %d", registers, HRChargeTargetSOC, HREnableCharge)
+	}
+	if s.Requests() != 1 {
+		t.Errorf("fake answered %d requests, want 1", s.Requests())
+	}
+}
+
+func TestReadInputRegister(t *testing.T) {
+	s, c := startFake(t)
+	s.SetInputRegister(IRBatterySOC, 63)
+
+	soc, err := c.GetSOC()
+	if err != nil {
+		t.Fatalf("GetSOC() error = %v", err)
+	}
+	if soc != 63 {
+		t.Errorf("GetSOC() = %v, want 63", soc)
+	}
+}
+
+func TestWriteHoldingRegister(t *testing.T) {
+	s, c := startFake(t)
+
+	err := c.WriteHoldingRegister(HRBatteryPowerMode, 1)
+	if err != nil {
+		t.Fatalf("WriteHoldingRegister() error = %v", err)
+	}
+	if got := s.HoldingRegister(HRBatteryPowerMode); got != 1 {
+		t.Errorf("register %d = %d, want 1", HRBatteryPowerMode, got)
+	}
+}
+
+func TestException(t *testing.T) {
+	_, c := startFake(t)
+
+	_, err := c.ReadHoldingRegisters(fakeRegisters, MaxRegisters)
+	if !errors.Is(err, ErrException) {
+		t.Errorf("ReadHoldingRegisters() error = %v, want ErrException", err)
+	}
+
+	err = c.WriteHoldingRegister(fakeRegisters, 1)
+	if !errors.Is(err, ErrException) {
+		t.Errorf("WriteHoldingRegister() error = %v, want ErrException", err)
+	}
+}
+
+func TestInvalidCheckCode(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, body, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+
+		// echo the write back with the check code of the request corrupted
+		body[len(body)-1]++
+		conn.Write(encodeFrame(functionTransparent, body))
+	}()
+
+	c := NewClient(l.Addr().String(), WithTimeout(time.Second))
+	err = c.WriteHoldingRegister(HRBatteryPowerMode, 1)
+	if err == nil {
+		t.Error("WriteHoldingRegister() error = nil, want an error for the invalid check code")
+	}
+}
@@ -0,0 +1,117 @@
+package modbus
+
+import (
+	"fmt"
+	"time"
+)
+
+// holding registers of gen 1 hybrid inverters, times are stored as hhmm in local time
+const (
+	HREnableChargeTarget    = 20
+	HRBatteryPowerMode      = 27 // 1 discharges to match demand (eco), 0 at full power
+	HRChargeSlot2Start      = 31
+	HRChargeSlot2End        = 32
+	HRDischargeSlot2Start   = 44
+	HRDischargeSlot2End     = 45
+	HRDischargeSlot1Start   = 56
+	HRDischargeSlot1End     = 57
+	HREnableDischarge       = 59
+	HRChargeSlot1Start      = 94
+	HRChargeSlot1End        = 95
+	HREnableCharge          = 96
+	HRBatterySOCReserve     = 110
+	HRBatteryChargeLimit    = 111
+	HRBatteryDischargeLimit = 112
+	HRChargeTargetSOC       = 116
+)
+
+// input registers
+const (
+	IRBatterySOC = 59
+)
+
+// ChargeSlots and DischargeSlots are the slot registers by slot number - 1
+var (
+	ChargeSlots    = [][2]uint16{{HRChargeSlot1Start, HRChargeSlot1End}, {HRChargeSlot2Start, HRChargeSlot2End}}
+	DischargeSlots = [][2]uint16{{HRDischargeSlot1Start, HRDischargeSlot1End}, {HRDischargeSlot2Start, HRDischargeSlot2End}}
+)
+
+// ReadHoldingRegister reads a single holding register, the inverter is read in aligned blocks of registers
+func (c *Client) ReadHoldingRegister(register uint16) (uint16, error) {
+	block, err := c.ReadHoldingRegisters(register/MaxRegisters*MaxRegisters, MaxRegisters)
+	if err != nil {
+		return 0, err
+	}
+	return block[register%MaxRegisters], nil
+}
+
+// ReadInputRegister reads a single input register, the inverter is read in aligned blocks of registers
+func (c *Client) ReadInputRegister(register uint16) (uint16, error) {
+	block, err := c.ReadInputRegisters(register/MaxRegisters*MaxRegisters, MaxRegisters)
+	if err != nil {
+		return 0, err
+	}
+	return block[register%MaxRegisters], nil
+}
+
+// GetSOC returns the live battery state of charge
+func (c *Client) GetSOC() (float64, error) {
+	soc, err := c.ReadInputRegister(IRBatterySOC)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(soc), nil
+}
+
+// SetChargeTarget sets the SOC AC charge stops at, the target is turned off at 100% as with the cloud
+func (c *Client) SetChargeTarget(target int) error {
+	if target < 0 || target > 100 {
+		return fmt.Errorf("invalid charge target %d", target)
+	}
+
+	err := c.WriteHoldingRegister(HREnableChargeTarget, boolRegister(target != 100))
+	if err != nil {
+		return err
+	}
+
+	return c.WriteHoldingRegister(HRChargeTargetSOC, uint16(target))
+}
+
+// SetSlot writes the start and end times of a slot, given as a pair of registers from ChargeSlots or DischargeSlots
+func (c *Client) SetSlot(registers [2]uint16, start, end time.Time) error {
+	err := c.WriteHoldingRegister(registers[0], TimeRegister(start))
+	if err != nil {
+		return err
+	}
+
+	return c.WriteHoldingRegister(registers[1], TimeRegister(end))
+}
+
+// EnableCharge turns AC charge during the charge slots on or off
+func (c *Client) EnableCharge(enabled bool) error {
+	return c.WriteHoldingRegister(HREnableCharge, boolRegister(enabled))
+}
+
+// EnableDischarge turns the discharge slots on or off
+func (c *Client) EnableDischarge(enabled bool) error {
+	return c.WriteHoldingRegister(HREnableDischarge, boolRegister(enabled))
+}
+
+// SetEco sets whether the battery discharges to match demand or at full power, exporting the excess
+func (c *Client) SetEco(eco bool) error {
+	return c.WriteHoldingRegister(HRBatteryPowerMode, boolRegister(eco))
+}
+
+// TimeRegister encodes the local time of day as hhmm
+func TimeRegister(t time.Time) uint16 {
+	t = t.Local()
+	return uint16(t.Hour()*100 + t.Minute())
+}
+
+func boolRegister(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
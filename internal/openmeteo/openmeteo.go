@@ -0,0 +1,230 @@
+// Package openmeteo estimates PV production from the Open-Meteo irradiance forecast for the plane of the array, normalised to
+// the solcast forecast shape. Open-Meteo gives a single forecast so the P10 and P90 estimates match the P50 estimate.
+package openmeteo
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/solcast"
+)
+
+type Client struct {
+	m           sync.RWMutex
+	c           *http.Client
+	baseURL     string
+	latitude    float64
+	longitude   float64
+	declination float64
+	azimuth     float64
+	kwp         float64
+	cacheDir    string
+	data        *solcast.ForecastData
+	history     *solcast.History
+}
+
+const (
+	dataCacheFile = "openMeteoData.gob"
+	forecastDays  = 7
+
+	// performanceRatio accounts for inverter, wiring, soiling and mismatch losses
+	performanceRatio = 0.86
+	// tempCoefficient is the fractional loss in output per degree of cell temperature above 25C
+	tempCoefficient = -0.004
+	// cellHeating is how far the cells run above the air temperature per W/m2 of irradiance
+	cellHeating = 0.03
+)
+
+// NewClient creates a client for the plane at latitude and longitude tilted declination degrees from horizontal and facing
+// azimuth degrees from south (-90 east, 90 west). Every update is recorded to history when it isn't nil.
+func NewClient(latitude, longitude, declination, azimuth, kwp float64, cacheDir string, history *solcast.History) *Client {
+	return &Client{
+		c:           http.DefaultClient,
+		baseURL:     "https://api.open-meteo.com/v1/forecast",
+		latitude:    latitude,
+		longitude:   longitude,
+		declination: declination,
+		azimuth:     azimuth,
+		kwp:         kwp,
+		cacheDir:    cacheDir,
+		history:     history,
+	}
+}
+
+type ForecastResponse struct {
+	Hourly struct {
+		Time                   []int64    `json:"time"`
+		GlobalTiltedIrradiance []*float64 `json:"global_tilted_irradiance"`
+		Temperature2m          []*float64 `json:"temperature_2m"`
+	} `json:"hourly"`
+	Error  bool   `json:"error"`
+	Reason string `json:"reason"`
+}
+
+func (c *Client) writeDataCache(data *solcast.ForecastData) error {
+	dataCacheFilePath := path.Join(c.cacheDir, dataCacheFile)
+	f, err := os.Create(dataCacheFilePath)
+	if err != nil {
+		return fmt.Errorf("error creating data cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(data)
+	if err != nil {
+		return fmt.Errorf("error encoding data cache file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) readDataCache() (*solcast.ForecastData, error) {
+	dataCacheFilePath := path.Join(c.cacheDir, dataCacheFile)
+	f, err := os.Open(dataCacheFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening data cache file: %w", err)
+	}
+	defer f.Close()
+
+	data := &solcast.ForecastData{}
+	err = gob.NewDecoder(f).Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding data cache file: %w", err)
+	}
+
+	return data, nil
+}
+
+func (c *Client) SetForecast(fcd solcast.ForecastData) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	sort.Slice(fcd.Forecasts, func(i, j int) bool {
+		return fcd.Forecasts[i].PeriodEnd.Before(fcd.Forecasts[j].PeriodEnd)
+	})
+
+	if fcd.UpdatedAt.IsZero() {
+		fcd.UpdatedAt = time.Now().UTC()
+	}
+
+	c.data = &fcd
+	if c.cacheDir != "" {
+		err := c.writeDataCache(&fcd)
+		if err != nil {
+			println(fmt.Errorf("error updating data cache: %w", err).Error())
+		}
+	}
+
+	if c.history != nil {
+		err := c.history.Record(fcd)
+		if err != nil {
+			println(fmt.Errorf("error recording forecast revision: %w", err).Error())
+		}
+	}
+	return nil
+}
+
+func (c *Client) UpdateForecast() error {
+	get, err := c.c.Get(fmt.Sprintf("%s?latitude=%g&longitude=%g&tilt=%g&azimuth=%g&hourly=global_tilted_irradiance,temperature_2m&timezone=GMT&timeformat=unixtime&past_days=1&forecast_days=%d",
+		c.baseURL, c.latitude, c.longitude, c.declination, c.azimuth, forecastDays))
+	if err != nil {
+		return err
+	}
+	defer get.Body.Close()
+
+	if get.StatusCode != http.StatusOK {
+		// errors usually give their reason but aren't always json, such as from a proxy
+		body, _ := ioutil.ReadAll(get.Body)
+		var errorResponse ForecastResponse
+		if json.Unmarshal(body, &errorResponse) == nil && errorResponse.Reason != "" {
+			return fmt.Errorf("unexpected response code %d: %s", get.StatusCode, errorResponse.Reason)
+		}
+		return fmt.Errorf("unexpected response code %d: %s", get.StatusCode, string(body))
+	}
+
+	var forecastResponse ForecastResponse
+	err = json.NewDecoder(get.Body).Decode(&forecastResponse)
+	if err != nil {
+		return err
+	}
+	if forecastResponse.Error {
+		return fmt.Errorf("error forecasting: %s", forecastResponse.Reason)
+	}
+
+	fcd, err := c.normalise(forecastResponse)
+	if err != nil {
+		return err
+	}
+
+	fcd.UpdatedAt = time.Now().UTC()
+	return c.SetForecast(*fcd)
+}
+
+// normalise estimates the array output from the irradiance on its plane, derated for cell temperature.
+// Open-Meteo irradiance is the mean over the preceding hour so each hour is split into two half hour periods.
+func (c *Client) normalise(fr ForecastResponse) (*solcast.ForecastData, error) {
+	hourly := fr.Hourly
+	if len(hourly.Time) == 0 || len(hourly.GlobalTiltedIrradiance) != len(hourly.Time) || len(hourly.Temperature2m) != len(hourly.Time) {
+		return nil, errors.New("no forecast periods returned")
+	}
+
+	fcd := &solcast.ForecastData{Forecasts: []solcast.Forecast{}}
+	for i, ts := range hourly.Time {
+		if hourly.GlobalTiltedIrradiance[i] == nil {
+			continue
+		}
+
+		gti := *hourly.GlobalTiltedIrradiance[i]
+		tempFactor := 1.0
+		if hourly.Temperature2m[i] != nil {
+			cellTemp := *hourly.Temperature2m[i] + gti*cellHeating
+			tempFactor = 1 + tempCoefficient*(cellTemp-25)
+		}
+
+		kw := (gti / 1000) * c.kwp * performanceRatio * tempFactor
+		if kw < 0 {
+			kw = 0
+		}
+
+		end := time.Unix(ts, 0).UTC()
+		for _, pe := range []time.Time{end.Add(-30 * time.Minute), end} {
+			fcd.Forecasts = append(fcd.Forecasts, solcast.Forecast{
+				PvEstimate:   kw,
+				PvEstimate10: kw,
+				PvEstimate90: kw,
+				PeriodEnd:    pe,
+				Period:       "PT30M",
+			})
+		}
+	}
+
+	return fcd, nil
+}
+
+func (c *Client) GetForecast() (*solcast.ForecastData, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.data == nil {
+		if c.cacheDir == "" {
+			return nil, errors.New("no open-meteo forecast data available")
+		}
+
+		fcd, err := c.readDataCache()
+		if err != nil {
+			return nil, fmt.Errorf("no open-meteo forecast data available: %w", err)
+		}
+		c.data = fcd
+	}
+
+	data := *c.data
+	return &data, nil
+}
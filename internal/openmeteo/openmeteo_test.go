@@ -0,0 +1,123 @@
+package openmeteo
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := NewClient(51.5, -0.1, 35, 0, 4, "", nil)
+	c.baseURL = srv.URL
+	return c
+}
+
+func TestUpdateForecast(t *testing.T) {
+	noon := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("latitude") != "51.5" || q.Get("longitude") != "-0.1" || q.Get("tilt") != "35" || q.Get("azimuth") != "0" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if q.Get("timeformat") != "unixtime" || q.Get("timezone") != "GMT" {
+			t.Errorf("unexpected time format in request %s", r.URL)
+		}
+
+		// the hour to 13:00 has no irradiance forecast yet
+		w.Write([]byte(`{
+  "hourly": {
+    "time": [1685617200, 1685620800, 1685624400],
+    "global_tilted_irradiance": [0, 1000, null],
+    "temperature_2m": [15, 25, 20]
+  }
+}`))
+	})
+
+	err := c.UpdateForecast()
+	if err != nil {
+		t.Fatalf("UpdateForecast() error = %v", err)
+	}
+
+	fcd, err := c.GetForecast()
+	if err != nil {
+		t.Fatalf("GetForecast() error = %v", err)
+	}
+	if fcd.UpdatedAt.IsZero() {
+		t.Error("UpdatedAt isn't set")
+	}
+
+	// each hour is split into two half hours, cells at 25C + 1000W/m2 * 0.03 are derated by 0.4% per degree over 25C
+	noonKw := 4 * 0.86 * (1 - 0.004*30)
+	want := []struct {
+		periodEnd time.Time
+		kw        float64
+	}{
+		{periodEnd: noon.Add(-90 * time.Minute), kw: 0},
+		{periodEnd: noon.Add(-time.Hour), kw: 0},
+		{periodEnd: noon.Add(-30 * time.Minute), kw: noonKw},
+		{periodEnd: noon, kw: noonKw},
+	}
+	if len(fcd.Forecasts) != len(want) {
+		t.Fatalf("got %d forecasts, want %d", len(fcd.Forecasts), len(want))
+	}
+	for i, w := range want {
+		forecast := fcd.Forecasts[i]
+		if !forecast.PeriodEnd.Equal(w.periodEnd) {
+			t.Errorf("forecast %d ends %s, want %s", i, forecast.PeriodEnd, w.periodEnd)
+		}
+		if math.Abs(forecast.PvEstimate-w.kw) > 1e-9 {
+			t.Errorf("period ending %s estimate = %v, want %v", forecast.PeriodEnd.Format("15:04"), forecast.PvEstimate, w.kw)
+		}
+		if forecast.PvEstimate10 != forecast.PvEstimate || forecast.PvEstimate90 != forecast.PvEstimate {
+			t.Errorf("period ending %s estimates %v/%v/%v, want them equal", forecast.PeriodEnd.Format("15:04"), forecast.PvEstimate10, forecast.PvEstimate, forecast.PvEstimate90)
+		}
+	}
+}
+
+func TestUpdateForecastError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": true, "reason": "Latitude must be in range of -90 to 90°."}`))
+	})
+
+	err := c.UpdateForecast()
+	if err == nil || !strings.Contains(err.Error(), "Latitude must be in range") {
+		t.Fatalf("UpdateForecast() error = %v, want the reason", err)
+	}
+
+	_, err = c.GetForecast()
+	if err == nil {
+		t.Error("GetForecast() error = nil, want no forecast after a failed update")
+	}
+}
+
+func TestUpdateForecastErrorNotJSON(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>502 Bad Gateway</html>"))
+	})
+
+	err := c.UpdateForecast()
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("UpdateForecast() error = %v, want the response code", err)
+	}
+}
+
+func TestUpdateForecastMismatchedSeries(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hourly": {"time": [1685624400], "global_tilted_irradiance": [], "temperature_2m": [25]}}`))
+	})
+
+	err := c.UpdateForecast()
+	if err == nil {
+		t.Error("UpdateForecast() error = nil, want an error for series of different lengths")
+	}
+}
@@ -0,0 +1,115 @@
+package solcast
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const budgetCacheFile = "solcastBudget.gob"
+
+var ErrBudgetExhausted = errors.New("solcast api call budget exhausted")
+
+// Budget tracks the api calls made during a UTC day, solcast resets its limits at UTC midnight
+type Budget struct {
+	Day   time.Time `json:"day"`
+	Used  int       `json:"used"`
+	Limit int       `json:"limit"`
+}
+
+func (b Budget) Remaining() int {
+	if b.Used > b.Limit {
+		return 0
+	}
+	return b.Limit - b.Used
+}
+
+// CallsPerUpdate is the number of api calls an UpdateForecast makes, forecasts and estimated actuals for each site
+func (c *Client) CallsPerUpdate() int {
+	return 2 * len(c.resourceIDs)
+}
+
+// GetBudget returns the calls used today
+func (c *Client) GetBudget() Budget {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return *c.currentBudget()
+}
+
+// currentBudget returns today's budget, loading it from the cache on first use, c.m must be held
+func (c *Client) currentBudget() *Budget {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	if c.budget == nil && c.cacheDir != "" {
+		b, err := c.readBudgetCache()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			println(fmt.Errorf("error reading budget cache: %w", err).Error())
+		}
+		c.budget = b
+	}
+
+	if c.budget == nil || !c.budget.Day.Equal(today) {
+		c.budget = &Budget{Day: today}
+	}
+	c.budget.Limit = c.dailyLimit
+
+	return c.budget
+}
+
+// checkBudget returns ErrBudgetExhausted when there's not enough budget left today for n calls, c.m must be held
+func (c *Client) checkBudget(n int) error {
+	b := c.currentBudget()
+	if b.Remaining() < n {
+		return fmt.Errorf("%w: %d of %d calls used today, %d needed", ErrBudgetExhausted, b.Used, b.Limit, n)
+	}
+
+	return nil
+}
+
+// useCall records an api call against today's budget, c.m must be held
+func (c *Client) useCall() {
+	b := c.currentBudget()
+	b.Used++
+
+	if c.cacheDir != "" {
+		err := c.writeBudgetCache(b)
+		if err != nil {
+			println(fmt.Errorf("error updating budget cache: %w", err).Error())
+		}
+	}
+}
+
+func (c *Client) writeBudgetCache(b *Budget) error {
+	f, err := os.Create(path.Join(c.cacheDir, budgetCacheFile))
+	if err != nil {
+		return fmt.Errorf("error creating budget cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(b)
+	if err != nil {
+		return fmt.Errorf("error encoding budget cache file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) readBudgetCache() (*Budget, error) {
+	f, err := os.Open(path.Join(c.cacheDir, budgetCacheFile))
+	if err != nil {
+		return nil, fmt.Errorf("error opening budget cache file: %w", err)
+	}
+	defer f.Close()
+
+	b := &Budget{}
+	err = gob.NewDecoder(f).Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding budget cache file: %w", err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,166 @@
+package solcast
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/store"
+)
+
+const (
+	revisionsBucket     = "solarForecastRevisions"
+	chargeTargetsBucket = "chargeTargets"
+	submissionsBucket   = "solcastSubmissions"
+	// keyFormat is fixed width so keys sort in time order
+	keyFormat = "2006-01-02T15:04:05.000000000Z"
+	// revisionSpan is how far either side of its update a revision can cover, 7 days of estimated actuals and forecasts
+	revisionSpan = 8 * 24 * time.Hour
+)
+
+// History keeps every revision of the solar forecast and the charge targets set from them
+type History struct {
+	s *store.Store
+}
+
+func NewHistory(s *store.Store) *History {
+	return &History{s: s}
+}
+
+// Revision is a forecast revision's view of a day
+type Revision struct {
+	UpdatedAt     time.Time  `json:"updated_at"`
+	ProductionKwh float64    `json:"production_kwh"`
+	Forecasts     []Forecast `json:"forecasts"`
+}
+
+// ChargeTarget records the charge target set for a day, the forecast revision it was based on and the consumption
+// assumed for each period keyed by period end
+type ChargeTarget struct {
+	SetAt        time.Time             `json:"set_at"`
+	Date         time.Time             `json:"date"`
+	Revision     time.Time             `json:"revision"`
+	TargetSOC    float64               `json:"target_soc"`
+	ConsumptionW map[time.Time]float64 `json:"consumption_w,omitempty"`
+}
+
+// Submission records the outcome of submitting a day's measurements to solcast
+type Submission struct {
+	SubmittedAt  time.Time `json:"submitted_at"`
+	Date         time.Time `json:"date"`
+	Period       string    `json:"period"`
+	Measurements int       `json:"measurements"`
+	Error        string    `json:"error,omitempty"`
+}
+
+type DayHistory struct {
+	Date          time.Time      `json:"date"`
+	Revisions     []Revision     `json:"revisions"`
+	ChargeTargets []ChargeTarget `json:"charge_targets"`
+}
+
+func key(t time.Time) string {
+	return t.UTC().Format(keyFormat)
+}
+
+// Record stores fcd as the revision at its UpdatedAt
+func (h *History) Record(fcd ForecastData) error {
+	if fcd.UpdatedAt.IsZero() {
+		return fmt.Errorf("forecast revision has no update time")
+	}
+
+	return h.s.Put(revisionsBucket, key(fcd.UpdatedAt), fcd)
+}
+
+// Get returns the revision updated at t
+func (h *History) Get(t time.Time) (*ForecastData, error) {
+	fcd := &ForecastData{}
+	ok, err := h.s.Get(revisionsBucket, key(t), fcd)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no forecast revision at %s", t.UTC().Format(time.RFC3339))
+	}
+
+	return fcd, nil
+}
+
+func (h *History) RecordChargeTarget(ct ChargeTarget) error {
+	return h.s.Put(chargeTargetsBucket, key(ct.SetAt), ct)
+}
+
+// Day returns how the forecast for the day starting at d evolved, oldest revision first, and the charge targets set for it
+func (h *History) Day(d time.Time) (*DayHistory, error) {
+	end := d.AddDate(0, 0, 1)
+	dh := &DayHistory{
+		Date:          d,
+		Revisions:     []Revision{},
+		ChargeTargets: []ChargeTarget{},
+	}
+
+	err := h.s.Range(revisionsBucket, key(d.Add(-revisionSpan)), key(end.Add(revisionSpan)), func(_ string, decode func(v interface{}) error) error {
+		var fcd ForecastData
+		err := decode(&fcd)
+		if err != nil {
+			return err
+		}
+
+		revision := Revision{UpdatedAt: fcd.UpdatedAt, Forecasts: []Forecast{}}
+		for _, forecast := range fcd.Forecasts {
+			if !forecast.PeriodEnd.After(d) || forecast.PeriodEnd.After(end) {
+				continue
+			}
+			revision.Forecasts = append(revision.Forecasts, forecast)
+			revision.ProductionKwh = revision.ProductionKwh + forecast.PvEstimate*0.5
+		}
+
+		if len(revision.Forecasts) > 0 {
+			dh.Revisions = append(dh.Revisions, revision)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.s.Range(chargeTargetsBucket, key(d.Add(-revisionSpan)), key(end.Add(revisionSpan)), func(_ string, decode func(v interface{}) error) error {
+		var ct ChargeTarget
+		err := decode(&ct)
+		if err != nil {
+			return err
+		}
+
+		if ct.Date.Equal(d) {
+			dh.ChargeTargets = append(dh.ChargeTargets, ct)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dh, nil
+}
+
+func (h *History) RecordSubmission(sub Submission) error {
+	return h.s.Put(submissionsBucket, key(sub.SubmittedAt), sub)
+}
+
+// Submissions returns the measurement submissions made since t, oldest first
+func (h *History) Submissions(since time.Time) ([]Submission, error) {
+	subs := []Submission{}
+	err := h.s.Range(submissionsBucket, key(since), "", func(_ string, decode func(v interface{}) error) error {
+		var sub Submission
+		err := decode(&sub)
+		if err != nil {
+			return err
+		}
+		subs = append(subs, sub)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
@@ -0,0 +1,186 @@
+package solcast
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Schedule gives the next activation after t, e.g. a cron.Schedule
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Refresher spends the day's api call budget refreshing the forecast, keeping a refresh in reserve for lead before each
+// priority activation (e.g. the charge target update) and spreading the rest evenly across daylight
+type Refresher struct {
+	c        *Client
+	priority Schedule
+	lead     time.Duration
+
+	m        sync.Mutex
+	failedAt time.Time // last failed refresh, zero after a success
+	failures int       // consecutive failed refreshes
+}
+
+const (
+	// retryBackoff is the wait after a failed refresh, doubling with each further failure up to maxRetryBackoff
+	retryBackoff    = 15 * time.Minute
+	maxRetryBackoff = 2 * time.Hour
+)
+
+type RefresherStatus struct {
+	Budget
+	Remaining   int       `json:"remaining"`
+	NextRefresh time.Time `json:"next_refresh"`
+}
+
+// NewRefresher creates a refresher for c, priority may be nil
+func NewRefresher(c *Client, priority Schedule, lead time.Duration) *Refresher {
+	return &Refresher{
+		c:        c,
+		priority: priority,
+		lead:     lead,
+	}
+}
+
+// Start checks every minute whether a refresh is due
+func (r *Refresher) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for now := range ticker.C {
+			next := r.Next(now)
+			if next.IsZero() || now.Before(next) {
+				continue
+			}
+
+			println("refreshing solcast forecast")
+			err := r.c.UpdateForecast()
+			if err != nil && !errors.Is(err, ErrBudgetExhausted) {
+				println(fmt.Errorf("error refreshing solcast forecast: %w", err).Error())
+			}
+
+			r.m.Lock()
+			if err != nil {
+				r.failedAt = now
+				r.failures++
+			} else {
+				r.failedAt = time.Time{}
+				r.failures = 0
+			}
+			r.m.Unlock()
+		}
+	}()
+}
+
+func (r *Refresher) Status() RefresherStatus {
+	budget := r.c.GetBudget()
+	return RefresherStatus{
+		Budget:      budget,
+		Remaining:   budget.Remaining(),
+		NextRefresh: r.Next(time.Now()),
+	}
+}
+
+// Next returns when the next refresh is due, zero when there's no budget left for one today. Refreshes are held back
+// after a failure so a persistent error doesn't retry every minute.
+func (r *Refresher) Next(now time.Time) time.Time {
+	next := r.next(now)
+	if next.IsZero() {
+		return next
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.failures == 0 {
+		return next
+	}
+
+	backoff := retryBackoff
+	for i := 1; i < r.failures && backoff < maxRetryBackoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+
+	if retry := r.failedAt.Add(backoff).UTC(); next.Before(retry) {
+		return retry
+	}
+	return next
+}
+
+func (r *Refresher) next(now time.Time) time.Time {
+	now = now.UTC()
+	day := now.Truncate(24 * time.Hour)
+
+	refreshes := r.c.GetBudget().Remaining() / r.c.CallsPerUpdate()
+	if refreshes == 0 {
+		return time.Time{}
+	}
+
+	// without a forecast yet refresh straight away, Next backs off if it fails
+	fcd, err := r.c.GetForecast()
+	if err != nil {
+		return now
+	}
+
+	// reserve a refresh ahead of each priority activation left today that the last refresh doesn't already cover
+	var pending []time.Time
+	if r.priority != nil {
+		for p := r.priority.Next(now); p.Before(day.Add(24 * time.Hour)); p = r.priority.Next(p) {
+			if fcd.UpdatedAt.Before(p.Add(-r.lead)) {
+				pending = append(pending, p.Add(-r.lead))
+			}
+		}
+	}
+
+	var next time.Time
+	if len(pending) > 0 {
+		next = pending[0]
+	}
+
+	free := refreshes - len(pending)
+	if free <= 0 {
+		return next
+	}
+
+	dawn, dusk := daylight(fcd, day)
+	start := dawn
+	if fcd.UpdatedAt.After(start) {
+		start = fcd.UpdatedAt
+	}
+	if !start.Before(dusk) || !now.Before(dusk) {
+		return next
+	}
+
+	spread := start.Add(dusk.Sub(start) / time.Duration(free+1))
+	if next.IsZero() || spread.Before(next) {
+		next = spread
+	}
+
+	return next
+}
+
+// daylight returns the first and last production of the day from the forecast, falling back to 06:00-18:00 UTC
+func daylight(fcd *ForecastData, day time.Time) (time.Time, time.Time) {
+	var dawn, dusk time.Time
+	for _, forecast := range fcd.Forecasts {
+		pe := forecast.PeriodEnd.UTC()
+		if !pe.After(day) || pe.After(day.Add(24*time.Hour)) || forecast.PvEstimate90 <= 0 {
+			continue
+		}
+
+		if dawn.IsZero() {
+			dawn = pe.Add(-30 * time.Minute)
+		}
+		dusk = pe
+	}
+
+	if dawn.IsZero() {
+		return day.Add(6 * time.Hour), day.Add(18 * time.Hour)
+	}
+
+	return dawn, dusk
+}
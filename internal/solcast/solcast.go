@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -15,24 +16,31 @@ import (
 )
 
 type Client struct {
-	m          sync.RWMutex
-	apiKey     string
-	baseURL    string
-	resourceID string
-	data       *ForecastData
-	c          *http.Client
-	cacheDir   string
+	m           sync.RWMutex
+	apiKey      string
+	baseURL     string
+	resourceIDs []string
+	data        *ForecastData
+	c           *http.Client
+	cacheDir    string
+	dailyLimit  int
+	budget      *Budget
+	history     *History
 }
 
 const dataCacheFile = "solcastData.gob"
 
-func NewClient(apiKey, resourceID, cacheDir string) *Client {
+// NewClient creates a client for one or more rooftop sites, the forecasts of multiple sites are summed e.g. for east and west arrays.
+// Calls to the API are limited to dailyLimit per UTC day. Every update is recorded to history when it isn't nil.
+func NewClient(apiKey string, resourceIDs []string, cacheDir string, dailyLimit int, history *History) *Client {
 	return &Client{
-		apiKey:     apiKey,
-		baseURL:    "https://api.solcast.com.au/rooftop_sites",
-		resourceID: resourceID,
-		c:          http.DefaultClient,
-		cacheDir:   cacheDir,
+		apiKey:      apiKey,
+		baseURL:     "https://api.solcast.com.au/rooftop_sites",
+		resourceIDs: resourceIDs,
+		c:           http.DefaultClient,
+		cacheDir:    cacheDir,
+		dailyLimit:  dailyLimit,
+		history:     history,
 	}
 }
 
@@ -41,7 +49,9 @@ type EstimatedActualData struct {
 }
 
 type ForecastData struct {
-	Forecasts []Forecast `json:"forecasts"`
+	Forecasts []Forecast            `json:"forecasts"`
+	Sites     map[string][]Forecast `json:"sites,omitempty"` // forecasts of each site keyed by resource id when there's more than one
+	UpdatedAt time.Time             `json:"updated_at"`
 }
 
 type Forecast struct {
@@ -85,6 +95,8 @@ func (c *Client) readDataCache() (*ForecastData, error) {
 	return data, nil
 }
 
+// SetForecast replaces the forecast with one given in place of fetching it, stamped as updated now unless it has its
+// own update time
 func (c *Client) SetForecast(fcd ForecastData) error {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -92,7 +104,16 @@ func (c *Client) SetForecast(fcd ForecastData) error {
 	sort.Slice(fcd.Forecasts, func(i, j int) bool {
 		return fcd.Forecasts[i].PeriodEnd.Before(fcd.Forecasts[j].PeriodEnd)
 	})
+	if fcd.UpdatedAt.IsZero() {
+		fcd.UpdatedAt = time.Now().UTC()
+	}
+
+	c.setForecast(fcd)
+	return nil
+}
 
+// setForecast keeps fcd as the current forecast, caching it and recording it as a revision. c.m must be held.
+func (c *Client) setForecast(fcd ForecastData) {
 	c.data = &fcd
 	if c.cacheDir != "" {
 		err := c.writeDataCache(&fcd)
@@ -100,76 +121,136 @@ func (c *Client) SetForecast(fcd ForecastData) error {
 			println(fmt.Errorf("error updating data cache: %w", err))
 		}
 	}
-	return nil
+
+	if c.history != nil {
+		err := c.history.Record(fcd)
+		if err != nil {
+			println(fmt.Errorf("error recording forecast revision: %w", err).Error())
+		}
+	}
 }
 
 func (c *Client) UpdateForecast() error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	fcd := ForecastData{Forecasts: []Forecast{}}
 
-	get, err := c.c.Get(fmt.Sprintf("%s/%s/forecasts?format=json&api_key=%s", c.baseURL, c.resourceID, c.apiKey))
+	err := c.checkBudget(c.CallsPerUpdate())
 	if err != nil {
 		return err
 	}
 
+	sites := make(map[string][]Forecast)
+	for _, resourceID := range c.resourceIDs {
+		forecasts, err := c.getSiteForecasts(resourceID)
+		if err != nil {
+			return fmt.Errorf("error getting forecasts for site %s: %w", resourceID, err)
+		}
+		sites[resourceID] = forecasts
+	}
+
+	fcd := merge(sites)
+	fcd.UpdatedAt = time.Now().UTC()
+	if len(sites) > 1 {
+		fcd.Sites = sites
+	}
+
+	c.setForecast(fcd)
+	return nil
+}
+
+func (c *Client) getSiteForecasts(resourceID string) ([]Forecast, error) {
+	forecasts := []Forecast{}
+
 	var forecastResponse ForecastData
-	err = json.NewDecoder(get.Body).Decode(&forecastResponse)
+	err := c.get(fmt.Sprintf("%s/%s/forecasts?format=json&api_key=%s", c.baseURL, resourceID, c.apiKey), &forecastResponse)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fcd.Forecasts = append(fcd.Forecasts, forecastResponse.Forecasts...)
+	forecasts = append(forecasts, forecastResponse.Forecasts...)
 
-	get, err = c.c.Get(fmt.Sprintf("%s/%s/estimated_actuals?format=json&api_key=%s", c.baseURL, c.resourceID, c.apiKey))
+	var actualsResponse EstimatedActualData
+	err = c.get(fmt.Sprintf("%s/%s/estimated_actuals?format=json&api_key=%s", c.baseURL, resourceID, c.apiKey), &actualsResponse)
+	if err != nil {
+		return nil, err
+	}
+	forecasts = append(forecasts, actualsResponse.Forecasts...)
+
+	sort.Slice(forecasts, func(i, j int) bool {
+		return forecasts[i].PeriodEnd.Before(forecasts[j].PeriodEnd)
+	})
+
+	return forecasts, nil
+}
+
+// get decodes a successful response from url into v, only successful calls are charged to the budget
+func (c *Client) get(url string, v interface{}) error {
+	resp, err := c.c.Get(url)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	var actualsResponse EstimatedActualData
-	err = json.NewDecoder(get.Body).Decode(&actualsResponse)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
 	if err != nil {
 		return err
 	}
+	c.useCall()
+
+	return nil
+}
 
-	fcd.Forecasts = append(fcd.Forecasts, actualsResponse.Forecasts...)
+// merge sums the estimates of each site by period end
+func merge(sites map[string][]Forecast) ForecastData {
+	merged := make(map[time.Time]*Forecast)
+	for _, forecasts := range sites {
+		for _, forecast := range forecasts {
+			pe := forecast.PeriodEnd.UTC()
+			m, ok := merged[pe]
+			if !ok {
+				m = &Forecast{
+					PeriodEnd: forecast.PeriodEnd,
+					Period:    forecast.Period,
+				}
+				merged[pe] = m
+			}
+			m.PvEstimate = m.PvEstimate + forecast.PvEstimate
+			m.PvEstimate10 = m.PvEstimate10 + forecast.PvEstimate10
+			m.PvEstimate90 = m.PvEstimate90 + forecast.PvEstimate90
+		}
+	}
 
+	fcd := ForecastData{Forecasts: []Forecast{}}
+	for _, forecast := range merged {
+		fcd.Forecasts = append(fcd.Forecasts, *forecast)
+	}
 	sort.Slice(fcd.Forecasts, func(i, j int) bool {
 		return fcd.Forecasts[i].PeriodEnd.Before(fcd.Forecasts[j].PeriodEnd)
 	})
 
-	c.data = &fcd
-	if c.cacheDir != "" {
-		err := c.writeDataCache(&fcd)
-		if err != nil {
-			println(fmt.Errorf("error updating data cache: %w", err))
-		}
-	}
-	return nil
+	return fcd
 }
 
 func (c *Client) GetForecast() (*ForecastData, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
 	if c.data == nil {
-		// probably don't want to let this auto fetch as we're limited to 10 requests a day
-		//err := c.UpdateForecast()
-		//if err != nil {
-		//	return nil, err
-		//}
-
-		if c.cacheDir != "" {
-			fcd, err := c.readDataCache()
-			if err != nil {
-				return nil, fmt.Errorf("no solcast forecast data available: %w", err)
-			}
+		// don't auto fetch as we're limited to 10 requests a day, see Refresher
+		if c.cacheDir == "" {
+			return nil, errors.New("no solcast forecast data available")
+		}
 
-			c.m.Lock()
-			c.data = fcd
-			c.m.Unlock()
+		fcd, err := c.readDataCache()
+		if err != nil {
+			return nil, fmt.Errorf("no solcast forecast data available: %w", err)
 		}
+		c.data = fcd
 	}
 
-	c.m.RLock()
-	defer c.m.RUnlock()
-
 	data := *c.data
 	return &data, nil
 }
@@ -180,20 +261,39 @@ type Measurement struct {
 	TotalPower float64   `json:"total_power"`
 }
 
+// ParsePeriod parses the measurement periods solcast accepts
+func ParsePeriod(period string) (time.Duration, error) {
+	switch period {
+	case "PT10M":
+		return 10 * time.Minute, nil
+	case "PT30M":
+		return 30 * time.Minute, nil
+	default:
+		return 0, fmt.Errorf("unsupported measurement period %s, use PT10M or PT30M", period)
+	}
+}
+
 type SubmitMeasurementsRequest struct {
 	Measurements []Measurement `json:"measurements"`
 }
 
+// SubmitMeasurements submits measurements for the site, only possible when the client has a single site
 func (c *Client) SubmitMeasurements(request *SubmitMeasurementsRequest) error {
+	if len(c.resourceIDs) != 1 {
+		return fmt.Errorf("measurements can only be submitted for a single site, %d configured", len(c.resourceIDs))
+	}
+
 	bodyBytes, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.c.Post(fmt.Sprintf("%s/%s/measurements?api_key=%s", c.baseURL, c.resourceID, c.apiKey), "application/json", bytes.NewBuffer(bodyBytes))
+	resp, err := c.c.Post(fmt.Sprintf("%s/%s/measurements?api_key=%s", c.baseURL, c.resourceIDs[0], c.apiKey), "application/json", bytes.NewBuffer(bodyBytes))
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := ioutil.ReadAll(resp.Body)
 		return fmt.Errorf("error submitting solar measurements: %d, %s", resp.StatusCode, string(respBody))
@@ -0,0 +1,41 @@
+package solcast
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/store"
+)
+
+func TestSetForecast(t *testing.T) {
+	st, err := store.Open(path.Join(t.TempDir(), "givforecast.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer st.Close()
+
+	c := NewClient("", nil, "", 0, NewHistory(st))
+	periodEnd := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	err = c.SetForecast(ForecastData{Forecasts: []Forecast{{PeriodEnd: periodEnd, PvEstimate: 2, Period: "PT30M"}}})
+	if err != nil {
+		t.Fatalf("SetForecast() error = %v", err)
+	}
+
+	fcd, err := c.GetForecast()
+	if err != nil {
+		t.Fatalf("GetForecast() error = %v", err)
+	}
+	if fcd.UpdatedAt.IsZero() {
+		t.Fatal("UpdatedAt isn't set")
+	}
+
+	// the set forecast is a revision like an updated one
+	revision, err := c.history.Get(fcd.UpdatedAt)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(revision.Forecasts) != 1 || revision.Forecasts[0].PvEstimate != 2 {
+		t.Errorf("recorded revision %+v, want the set forecast", revision.Forecasts)
+	}
+}
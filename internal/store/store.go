@@ -0,0 +1,108 @@
+// Package store persists gob encoded values in buckets of an embedded bbolt database, keys sort lexically
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the database at path, creating it if it doesn't exist
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put stores v under key in bucket, replacing any existing value
+func (s *Store) Put(bucket, key string, v interface{}) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return fmt.Errorf("error encoding %s/%s: %w", bucket, key, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), buf.Bytes())
+	})
+}
+
+// Get decodes the value under key in bucket into v, returning false when there isn't one
+func (s *Store) Get(bucket, key string, v interface{}) (bool, error) {
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		data := b.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting %s/%s: %w", bucket, key, err)
+	}
+
+	return found, nil
+}
+
+// Range calls fn in key order for each key in bucket from from up to but not including to, fn decodes the value with decode.
+// An empty to ranges to the end of the bucket.
+func (s *Store) Range(bucket, from, to string, fn func(key string, decode func(v interface{}) error) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, data := c.Seek([]byte(from)); k != nil && (to == "" || bytes.Compare(k, []byte(to)) < 0); k, data = c.Next() {
+			err := fn(string(k), func(v interface{}) error {
+				return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Keys lists the keys in bucket in order
+func (s *Store) Keys(bucket string) ([]string, error) {
+	var keys []string
+	err := s.Range(bucket, "", "", func(key string, _ func(v interface{}) error) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,57 @@
+package tariff
+
+import (
+	"time"
+
+	"github.com/jakekeeys/givforecast/internal/store"
+)
+
+const (
+	ratesBucket = "tariffRates"
+	// keyFormat is fixed width so keys sort in time order
+	keyFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+// History keeps every rate the tariff has had so past days can be priced with the rates they had at the time
+type History struct {
+	s *store.Store
+}
+
+func NewHistory(s *store.Store) *History {
+	return &History{s: s}
+}
+
+func key(t time.Time) string {
+	return t.UTC().Format(keyFormat)
+}
+
+// Record stores each rate under its start, replacing any rate previously recorded for the same half hour
+func (h *History) Record(rd RateData) error {
+	for _, rate := range rd.Rates {
+		err := h.s.Put(ratesBucket, key(rate.ValidFrom), rate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rates returns the recorded rates starting from from up to but not including to, in order
+func (h *History) Rates(from, to time.Time) (*RateData, error) {
+	rd := &RateData{Rates: []Rate{}}
+	err := h.s.Range(ratesBucket, key(from), key(to), func(_ string, decode func(v interface{}) error) error {
+		var rate Rate
+		err := decode(&rate)
+		if err != nil {
+			return err
+		}
+		rd.Rates = append(rd.Rates, rate)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rd, nil
+}
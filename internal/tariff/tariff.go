@@ -0,0 +1,207 @@
+package tariff
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Client struct {
+	m        sync.RWMutex
+	c        *http.Client
+	source   string
+	cacheDir string
+	history  *History
+	data     *RateData
+}
+
+const dataCacheFile = "tariffData.gob"
+
+// NewClient creates a client reading half-hourly unit rates in the Octopus Agile format from source, either a http(s) URL or a local file path.
+// Every rate set is recorded to history when it isn't nil.
+func NewClient(source, cacheDir string, history *History) *Client {
+	return &Client{
+		c:        http.DefaultClient,
+		source:   source,
+		cacheDir: cacheDir,
+		history:  history,
+	}
+}
+
+type RateData struct {
+	Rates []Rate `json:"results"`
+}
+
+type Rate struct {
+	ValueExcVat float64   `json:"value_exc_vat"`
+	ValueIncVat float64   `json:"value_inc_vat"`
+	ValidFrom   time.Time `json:"valid_from"`
+	ValidTo     time.Time `json:"valid_to"`
+}
+
+// At returns the rate covering t
+func (rd *RateData) At(t time.Time) (Rate, bool) {
+	i := sort.Search(len(rd.Rates), func(i int) bool {
+		return rd.Rates[i].ValidTo.After(t)
+	})
+	if i < len(rd.Rates) && !rd.Rates[i].ValidFrom.After(t) {
+		return rd.Rates[i], true
+	}
+
+	return Rate{}, false
+}
+
+func (c *Client) writeDataCache(data *RateData) error {
+	dataCacheFilePath := path.Join(c.cacheDir, dataCacheFile)
+	f, err := os.Create(dataCacheFilePath)
+	if err != nil {
+		return fmt.Errorf("error creating data cache file: %w", err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(data)
+	if err != nil {
+		return fmt.Errorf("error encoding data cache file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) readDataCache() (*RateData, error) {
+	dataCacheFilePath := path.Join(c.cacheDir, dataCacheFile)
+	f, err := os.Open(dataCacheFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening data cache file: %w", err)
+	}
+	defer f.Close()
+
+	data := &RateData{}
+	err = gob.NewDecoder(f).Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding data cache file: %w", err)
+	}
+
+	return data, nil
+}
+
+func (c *Client) SetRates(rd RateData) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	sort.Slice(rd.Rates, func(i, j int) bool {
+		return rd.Rates[i].ValidFrom.Before(rd.Rates[j].ValidFrom)
+	})
+
+	c.data = &rd
+	if c.cacheDir != "" {
+		err := c.writeDataCache(&rd)
+		if err != nil {
+			println(fmt.Errorf("error updating data cache: %w", err).Error())
+		}
+	}
+	if c.history != nil {
+		err := c.history.Record(rd)
+		if err != nil {
+			println(fmt.Errorf("error recording tariff rates: %w", err).Error())
+		}
+	}
+	return nil
+}
+
+func (c *Client) UpdateRates() error {
+	if c.source == "" {
+		return errors.New("no tariff source configured")
+	}
+
+	rd := RateData{Rates: []Rate{}}
+	if !strings.HasPrefix(c.source, "http://") && !strings.HasPrefix(c.source, "https://") {
+		f, err := os.Open(c.source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = json.NewDecoder(f).Decode(&rd)
+		if err != nil {
+			return err
+		}
+
+		return c.SetRates(rd)
+	}
+
+	type RatesResponse struct {
+		Next    string `json:"next"`
+		Results []Rate `json:"results"`
+	}
+
+	next := c.source
+	for next != "" {
+		get, err := c.c.Get(next)
+		if err != nil {
+			return err
+		}
+
+		if get.StatusCode != http.StatusOK {
+			get.Body.Close()
+			return fmt.Errorf("unexpected response code %d", get.StatusCode)
+		}
+
+		var ratesResponse RatesResponse
+		err = json.NewDecoder(get.Body).Decode(&ratesResponse)
+		get.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		rd.Rates = append(rd.Rates, ratesResponse.Results...)
+		next = ratesResponse.Next
+	}
+
+	return c.SetRates(rd)
+}
+
+func (c *Client) GetRates() (*RateData, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.data == nil {
+		if c.cacheDir == "" {
+			return nil, errors.New("no tariff rates available")
+		}
+
+		rd, err := c.readDataCache()
+		if err != nil {
+			return nil, fmt.Errorf("no tariff rates available: %w", err)
+		}
+		c.data = rd
+	}
+
+	data := *c.data
+	return &data, nil
+}
+
+// GetRatesBetween returns the rates the tariff had from from up to to, as recorded when they were set, so past days
+// are priced with their own rates rather than today's
+func (c *Client) GetRatesBetween(from, to time.Time) (*RateData, error) {
+	if c.history == nil {
+		return nil, errors.New("no tariff rate history available")
+	}
+
+	rd, err := c.history.Rates(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(rd.Rates) == 0 {
+		return nil, fmt.Errorf("no tariff rates recorded from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	return rd, nil
+}
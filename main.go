@@ -3,47 +3,146 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jakekeeys/givforecast/internal/accuracy"
 	"github.com/jakekeeys/givforecast/internal/api"
+	"github.com/jakekeeys/givforecast/internal/backtest"
+	"github.com/jakekeeys/givforecast/internal/config"
 	"github.com/jakekeeys/givforecast/internal/forecaster"
+	"github.com/jakekeeys/givforecast/internal/forecastsolar"
 	"github.com/jakekeeys/givforecast/internal/givenergy"
 	"github.com/jakekeeys/givforecast/internal/givtcp"
+	"github.com/jakekeeys/givforecast/internal/inverter"
+	"github.com/jakekeeys/givforecast/internal/modbus"
+	"github.com/jakekeeys/givforecast/internal/openmeteo"
 	"github.com/jakekeeys/givforecast/internal/solcast"
+	"github.com/jakekeeys/givforecast/internal/store"
+	"github.com/jakekeeys/givforecast/internal/tariff"
 )
 
+// biasLearningDays is how many days of accuracy history the bias factors are learned from
+const biasLearningDays = 90
+
 func main() {
-	r := gin.Default()
+	cfgPath := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		panic(err)
+	}
 
-	tzl := os.Getenv("TZ_LOCATION")
-	if tzl != "" {
-		loc, err := time.LoadLocation(tzl)
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
 		if err != nil {
 			panic(err)
 		}
 		time.Local = loc
 	}
 
-	sc := solcast.NewClient(os.Getenv("SOLCAST_API_KEY"), os.Getenv("SOLCAST_RESOURCE_ID"), os.Getenv("CACHE_DIR"))
-	gec := givenergy.NewClient(strings.Split(os.Getenv("GIVENERGY_SERIALS"), ","), os.Getenv("GIVENERGY_API_KEY"), os.Getenv("GIVENERGY_EMS") == "true")
-	f := forecaster.New(sc, gec)
-	gtcpc := givtcp.NewClient()
-	s := api.NewServer(f, sc, gtcpc, gec)
+	if len(os.Args) > 1 && os.Args[1] == "backtest" {
+		runBacktest(cfg, os.Args[2:])
+		return
+	}
+
+	r := gin.Default()
+
+	var st *store.Store
+	var history *solcast.History
+	if cfg.CacheDir != "" {
+		st, err = store.Open(path.Join(cfg.CacheDir, "givforecast.db"))
+		if err != nil {
+			panic(err)
+		}
+		defer st.Close()
+		history = solcast.NewHistory(st)
+	}
+
+	var sc forecaster.SolarForecastProvider
+	var scr *solcast.Refresher
+	solar := cfg.Solar
+	switch solar.Provider {
+	case "", "solcast":
+		scc := solcast.NewClient(cfg.Solcast.APIKey, cfg.Solcast.ResourceIDs, cfg.CacheDir, cfg.Solcast.DailyLimit, history)
+		if cfg.Solcast.AutoRefresh {
+			var priority solcast.Schedule
+			if cfg.Cron.UpdateTarget != "" {
+				schedule, err := cron.ParseStandard(cfg.Cron.UpdateTarget)
+				if err != nil {
+					panic(fmt.Errorf("err parsing update target cron: %w", err))
+				}
+				priority = schedule
+			}
+			scr = solcast.NewRefresher(scc, priority, 10*time.Minute)
+		}
+		sc = scc
+	case "forecastsolar":
+		sc = forecastsolar.NewClient(solar.ForecastSolarAPIKey, solar.Latitude, solar.Longitude, solar.Declination, solar.Azimuth, solar.Kwp, cfg.CacheDir, history)
+	case "openmeteo":
+		sc = openmeteo.NewClient(solar.Latitude, solar.Longitude, solar.Declination, solar.Azimuth, solar.Kwp, cfg.CacheDir, history)
+	default:
+		panic(fmt.Errorf("unknown solar provider %s", solar.Provider))
+	}
+
+	gec := newGivEnergyClient(cfg)
+
+	var fopts []forecaster.Option
+	tc := newTariffClient(cfg, st)
+	if tc != nil {
+		fopts = append(fopts, forecaster.WithTariff(tc))
+	}
+
+	if cfg.GivEnergy.APIKey != "" && len(cfg.GivEnergy.Serials) > 0 {
+		_, err := gec.Discover()
+		if err != nil {
+			println(fmt.Errorf("err discovering battery and inverter parameters, using the last discovered: %w", err).Error())
+		}
+	}
+
+	fc, err := cfg.ForecasterConfig(lastDiscovery(gec))
+	if err != nil {
+		panic(err)
+	}
+
+	copts := []forecaster.Option{forecaster.WithConfig(&fc)}
+	if cfg.CacheDir != "" {
+		copts = append(copts, forecaster.WithConfigDir(cfg.CacheDir))
+	}
+
+	f := forecaster.New(sc, gec, append(copts, fopts...)...)
+	var at *accuracy.Tracker
+	var bt *backtest.Backtester
+	if history != nil {
+		at = accuracy.NewTracker(st, history, gec, f)
+		bt = backtest.NewBacktester(history, gec, fopts...)
+	}
+
+	s := api.NewServer(f, sc, newInverterController(cfg, gec), gec, tc, scr, history, at, bt)
 
 	r.GET("/", s.RootHandler)
 
+	r.GET("/week", s.RangeChartHandler)
+
 	r.GET("/forecast", s.ForecastHandler)
+	r.GET("/forecast/range", s.ForecastRangeHandler)
 	r.GET("/forecast/now", s.ForecastNowHandler)
+	r.GET("/forecast/hindsight", s.GetHindsightHandler)
+	r.POST("/forecast/hindsight", s.EvaluateHindsightHandler)
+	r.POST("/forecast/replan", s.ReplanHandler)
 	r.GET("/forecast/config", s.ConfigHandler)
 	r.PUT("/forecast/config", s.SetConfigHandler)
+	r.DELETE("/forecast/config", s.ResetConfigHandler)
 	r.PUT("/forecast/config/consumptionaverage", s.SetConsumptionAverage)
 	r.PUT("/forecast/config/batteryupper", s.SetBatteryUpper)
 	r.PUT("/forecast/config/batterylower", s.SetBatteryLower)
 	r.PUT("/forecast/config/automatictargets", s.SetAutomaticTargets)
+	r.PUT("/forecast/config/biascorrection", s.SetBiasCorrection)
+	r.GET("/forecast/config/biasfactors", s.GetBiasFactorsHandler)
+	r.POST("/forecast/config/biasfactors", s.LearnBiasFactorsHandler)
 
 	r.POST("/givtcp/chargetarget", s.UpdateChargeTargetHandler)
 	r.PUT("/givtcp/chargetarget", s.SetChargeTargetHandler)
@@ -51,20 +150,36 @@ func main() {
 	r.POST("/soclast/forecast", s.UpdateForecastDataHandler)
 	r.PUT("/solcast/forecast", s.SetForecastDataHandler)
 	r.GET("/solcast/forecast", s.GetForecastDataHandler)
-	//r.POST("/solcast/actuals", s.SubmitSolarActualsHandler)
+	r.GET("/solcast/forecast/history", s.GetForecastHistoryHandler)
+	r.GET("/solcast/budget", s.GetSolcastBudgetHandler)
+	r.POST("/solcast/actuals", s.SubmitSolarActualsHandler)
+	r.GET("/solcast/actuals", s.GetSolarActualsSubmissionsHandler)
 
-	//r.POST("/givenergy/consumptionaverages", s.UpdateConsumptionAveragesHandler)
-	//r.GET("/givenergy/consumptionaverages", s.GetConsumptionAveragesHandler)
-	//r.PUT("/givenergy/consumptionaverages", s.SetConsumptionAveragesHandler)
-	//r.GET("/givenergy/batterydata", s.GetBatteryDataHandler)
+	r.POST("/solar/forecast", s.UpdateForecastDataHandler)
+	r.PUT("/solar/forecast", s.SetForecastDataHandler)
+	r.GET("/solar/forecast", s.GetForecastDataHandler)
+
+	r.POST("/tariff/rates", s.UpdateTariffRatesHandler)
+	r.PUT("/tariff/rates", s.SetTariffRatesHandler)
+	r.GET("/tariff/rates", s.GetTariffRatesHandler)
 
-	// todo post actual measurements production measurement from ge to solcast
+	r.POST("/backtest", s.BacktestHandler)
+
+	r.GET("/accuracy", s.GetAccuracyHandler)
+	r.GET("/accuracy/chart", s.AccuracyChartHandler)
+	r.POST("/accuracy", s.EvaluateAccuracyHandler)
+
+	r.POST("/givenergy/consumptionaverages", s.UpdateConsumptionAveragesHandler)
+	r.GET("/givenergy/consumptionaverages", s.GetConsumptionAveragesHandler)
+	r.PUT("/givenergy/consumptionaverages", s.SetConsumptionAveragesHandler)
+	r.GET("/givenergy/verifications", s.GetVerificationsHandler)
+	r.GET("/givenergy/discovery", s.GetDiscoveryHandler)
+	//r.GET("/givenergy/batterydata", s.GetBatteryDataHandler)
 
 	c := cron.New(cron.WithLocation(time.UTC))
 
-	uc := os.Getenv("UPDATE_TARGET_CRON")
-	if uc != "" {
-		_, err := c.AddFunc(uc, func() {
+	if cfg.Cron.UpdateTarget != "" {
+		_, err := c.AddFunc(cfg.Cron.UpdateTarget, func() {
 			err := s.UpdateChargeTarget()
 			if err != nil {
 				println(fmt.Errorf("err updating charge target: %w", err).Error())
@@ -75,23 +190,199 @@ func main() {
 		}
 	}
 
-	//ss := os.Getenv("SUBMIT_SOLAR_CRON")
-	//if ss != "" {
-	//	_, err := c.AddFunc(ss, func() {
-	//		err := s.SubmitSolarActuals()
-	//		if err != nil {
-	//			println(fmt.Errorf("err submitting solar measurements: %w", err).Error())
-	//		}
-	//	})
-	//	if err != nil {
-	//		panic(fmt.Errorf("err scheduling UpdateChargeTarget: %w", err))
-	//	}
-	//}
+	if cfg.Cron.Replan != "" {
+		_, err := c.AddFunc(cfg.Cron.Replan, func() {
+			_, err := s.Replan()
+			if err != nil {
+				println(fmt.Errorf("err replanning: %w", err).Error())
+			}
+		})
+		if err != nil {
+			panic(fmt.Errorf("err scheduling Replan: %w", err))
+		}
+	}
+
+	if cfg.Cron.UpdateTariff != "" && tc != nil {
+		_, err := c.AddFunc(cfg.Cron.UpdateTariff, func() {
+			err := tc.UpdateRates()
+			if err != nil {
+				println(fmt.Errorf("err updating tariff rates: %w", err).Error())
+			}
+		})
+		if err != nil {
+			panic(fmt.Errorf("err scheduling UpdateRates: %w", err))
+		}
+	}
+
+	if cfg.Cron.Accuracy != "" && at != nil {
+		_, err := c.AddFunc(cfg.Cron.Accuracy, func() {
+			_, err := s.EvaluateAccuracy()
+			if err != nil {
+				println(fmt.Errorf("err evaluating forecast accuracy: %w", err).Error())
+				return
+			}
+
+			_, err = s.LearnBias(biasLearningDays)
+			if err != nil {
+				println(fmt.Errorf("err learning bias factors: %w", err).Error())
+			}
+		})
+		if err != nil {
+			panic(fmt.Errorf("err scheduling EvaluateAccuracy: %w", err))
+		}
+	}
+
+	if cfg.Cron.Hindsight != "" && at != nil {
+		_, err := c.AddFunc(cfg.Cron.Hindsight, func() {
+			_, err := s.EvaluateHindsight()
+			if err != nil {
+				println(fmt.Errorf("err evaluating charge target hindsight: %w", err).Error())
+			}
+		})
+		if err != nil {
+			panic(fmt.Errorf("err scheduling EvaluateHindsight: %w", err))
+		}
+	}
+
+	// the inverters measure the sites' combined generation so it can't be split between them
+	if cfg.Cron.SubmitSolar != "" && len(cfg.Solcast.ResourceIDs) > 1 {
+		println(fmt.Sprintf("not scheduling solar measurement submission, measurements can only be submitted for a single solcast site and %d are configured", len(cfg.Solcast.ResourceIDs)))
+	} else if cfg.Cron.SubmitSolar != "" {
+		smp := cfg.Solcast.MeasurementPeriod
+		_, err := solcast.ParsePeriod(smp)
+		if err != nil {
+			panic(fmt.Errorf("err parsing solcast measurement period: %w", err))
+		}
+
+		_, err = c.AddFunc(cfg.Cron.SubmitSolar, func() {
+			_, err := s.SubmitSolarActuals(smp)
+			if err != nil {
+				println(fmt.Errorf("err submitting solar measurements: %w", err).Error())
+			}
+		})
+		if err != nil {
+			panic(fmt.Errorf("err scheduling SubmitSolarActuals: %w", err))
+		}
+	}
+
+	if at != nil {
+		_, err := s.LearnBias(biasLearningDays)
+		if err != nil {
+			println(fmt.Errorf("err learning bias factors: %w", err).Error())
+		}
+	}
+
+	// the forecaster config is rebuilt when the config file changes or parameters are rediscovered, the lock guards cfg
+	var cfgM sync.Mutex
+	reloadForecaster := func(nc *config.Config) error {
+		fc, err := nc.ForecasterConfig(lastDiscovery(gec))
+		if err != nil {
+			return err
+		}
+
+		err = f.Reload(fc)
+		if err != nil {
+			return err
+		}
+		println("reloaded forecaster config")
+		return nil
+	}
+
+	if cfg.Cron.Discovery != "" {
+		_, err := c.AddFunc(cfg.Cron.Discovery, func() {
+			_, err := gec.Discover()
+			if err != nil {
+				println(fmt.Errorf("err discovering battery and inverter parameters: %w", err).Error())
+				return
+			}
+
+			cfgM.Lock()
+			defer cfgM.Unlock()
+			err = reloadForecaster(cfg)
+			if err != nil {
+				println(fmt.Errorf("err reloading forecaster config: %w", err).Error())
+			}
+		})
+		if err != nil {
+			panic(fmt.Errorf("err scheduling Discover: %w", err))
+		}
+	}
+
+	// only the forecaster section is applied on reload, the rest is wired up at startup
+	if cfgPath != "" {
+		config.Watch(cfgPath, 10*time.Second, func(nc *config.Config) {
+			cfgM.Lock()
+			defer cfgM.Unlock()
+
+			for _, section := range nc.Changed(cfg) {
+				if section != "forecaster" {
+					println(fmt.Sprintf("config %s changed, restart to apply", section))
+				}
+			}
+
+			err := reloadForecaster(nc)
+			if err != nil {
+				println(fmt.Errorf("err reloading forecaster config: %w", err).Error())
+				return
+			}
+			cfg = nc
+		})
+	}
 
 	c.Start()
+	if scr != nil {
+		scr.Start()
+	}
 
-	err := r.Run(":8080")
+	err = r.Run(fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		panic(err)
 	}
 }
+
+func newGivEnergyClient(cfg *config.Config) *givenergy.Client {
+	return givenergy.NewClient(cfg.GivEnergy.Serials, cfg.GivEnergy.APIKey, cfg.GivEnergy.EMS, cfg.CacheDir, cfg.GivEnergy.ConsumptionHistoryDays)
+}
+
+// newInverterController controls the inverter through the configured api, failing over from givtcp to the cloud
+// when the local api is down
+func newInverterController(cfg *config.Config, gec *givenergy.Client) inverter.Controller {
+	switch cfg.Inverter.Controller {
+	case "", "cloud":
+		return inverter.NewCloud(gec)
+	case "givtcp":
+		return inverter.NewGivTCP(givtcp.NewClient(givtcp.WithBaseURL(cfg.GivTCP.URL)))
+	case "modbus":
+		if cfg.Inverter.ModbusAddress == "" {
+			panic("a modbus address must be set to control the inverter over modbus")
+		}
+		return inverter.NewModbus(modbus.NewClient(cfg.Inverter.ModbusAddress))
+	case "failover":
+		return inverter.NewFailover(inverter.NewGivTCP(givtcp.NewClient(givtcp.WithBaseURL(cfg.GivTCP.URL))), inverter.NewCloud(gec), 5*time.Minute)
+	default:
+		panic(fmt.Errorf("unknown inverter controller %s", cfg.Inverter.Controller))
+	}
+}
+
+// lastDiscovery returns the last discovered battery and inverter parameters, nil when there aren't any
+func lastDiscovery(gec *givenergy.Client) *givenergy.Discovery {
+	d, err := gec.GetDiscovery()
+	if err != nil {
+		return nil
+	}
+	return d
+}
+
+// newTariffClient returns nil when no tariff source is configured, rates are recorded to st when it isn't nil
+func newTariffClient(cfg *config.Config, st *store.Store) *tariff.Client {
+	if cfg.Tariff.Source == "" {
+		return nil
+	}
+
+	var history *tariff.History
+	if st != nil {
+		history = tariff.NewHistory(st)
+	}
+
+	return tariff.NewClient(cfg.Tariff.Source, cfg.CacheDir, history)
+}